@@ -5,6 +5,7 @@ import (
 	"log"
 	"net/url"
 	"os"
+	"regexp"
 
 	"github.com/joho/godotenv"
 	"github.com/urfave/cli"
@@ -40,25 +41,178 @@ func NewApp() *cli.App {
 		cli.StringFlag{
 			Name:  "migrations-dir, d",
 			Value: dbmate.DefaultMigrationsDir,
-			Usage: "specify the directory containing migration files",
+			Usage: "specify the directory containing migration files (separate several directories with : or ; on Windows)",
+		},
+		cli.BoolFlag{
+			Name:  "migrations-recursive",
+			Usage: "also find migration files in subdirectories of the migrations directory",
+		},
+		cli.StringFlag{
+			Name:  "migrations-pattern",
+			Usage: "regular expression matching the names of migration files (default: ^\\d.*\\.sql$)",
 		},
 		cli.StringFlag{
 			Name:  "schema-file, s",
 			Value: dbmate.DefaultSchemaFile,
 			Usage: "specify the schema file location",
 		},
+		cli.StringFlag{
+			Name:  "migrations-table",
+			Value: "schema_migrations",
+			Usage: "specify the table recording applied migrations, optionally as schema.table",
+		},
+		cli.StringFlag{
+			Name:  "version-file",
+			Usage: "write the current migration version to this file on migrate/rollback",
+		},
 		cli.BoolFlag{
 			Name:  "no-dump-schema",
 			Usage: "don't update the schema file on migrate/rollback",
 		},
+		cli.BoolFlag{
+			Name:  "dump-schema-per-table",
+			Usage: "dump the schema of each table to a separate file, in a directory named after the schema file",
+		},
+		cli.StringFlag{
+			Name:  "normalize-line-endings",
+			Usage: "convert the line endings of the schema file to lf or crlf",
+		},
 		cli.BoolFlag{
 			Name:  "wait",
 			Usage: "wait for the db to become available before executing the subsequent command",
 		},
+		cli.StringSliceFlag{
+			Name:  "wait-url",
+			Usage: "also wait for this database server (e.g. a replica) to become available, can be repeated",
+		},
 		cli.BoolFlag{
 			Name:  "dbmate-engine",
 			Usage: "use DBMate engine for scripts execution (experimental)",
 		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "print the scripts migrate/rollback would execute, without modifying the database",
+		},
+		cli.BoolFlag{
+			Name:  "strict",
+			Usage: "run static checks on migration files before applying them",
+		},
+		cli.BoolFlag{
+			Name:  "validate-checksums",
+			Usage: "record migration checksums, and warn about applied migrations which were modified",
+		},
+		cli.BoolFlag{
+			Name:  "fail-on-checksum-mismatch",
+			Usage: "refuse to migrate when applied migrations were modified (implies --validate-checksums)",
+		},
+		cli.StringFlag{
+			Name:  "checksum-algo",
+			Value: "sha256",
+			Usage: "algorithm of the checksums recorded by --validate-checksums (sha256, sha384 or sha512)",
+		},
+		cli.BoolFlag{
+			Name:  "check-foreign-keys",
+			Usage: "after applying migrations, fail if existing rows violate foreign key constraints",
+		},
+		cli.BoolFlag{
+			Name:  "record-batches",
+			Usage: "record a batch identifier with the migrations applied by each migrate run",
+		},
+		cli.BoolFlag{
+			Name:  "record-timings",
+			Usage: "record when each migration is applied, and how long it takes",
+		},
+		cli.DurationFlag{
+			Name:  "delay-between-migrations",
+			Usage: "pause between applying two migrations, e.g. 30s, to let replicas catch up",
+		},
+		cli.DurationFlag{
+			Name:  "max-replica-lag",
+			Usage: "wait after each migration until the replication lag drops below this duration",
+		},
+		cli.DurationFlag{
+			Name:  "replica-lag-interval",
+			Value: dbmate.DefaultReplicaLagInterval,
+			Usage: "length of time between replication lag checks",
+		},
+		cli.DurationFlag{
+			Name:  "replica-lag-timeout",
+			Value: dbmate.DefaultReplicaLagTimeout,
+			Usage: "maximum time to wait for the replication lag to drop",
+		},
+		cli.DurationFlag{
+			Name:  "statement-timeout",
+			Usage: "cancel any migration statement running longer than this duration, e.g. 5m",
+		},
+		cli.StringFlag{
+			Name:  "before-migrate-sql",
+			Usage: "statement to execute once before applying migrations (e.g. to enable a maintenance flag)",
+		},
+		cli.StringFlag{
+			Name:  "after-migrate-sql",
+			Usage: "statement to execute once after applying migrations, even if a migration failed",
+		},
+		cli.StringFlag{
+			Name:  "before-each-migration",
+			Usage: "SQL to execute before each migration, in the same transaction",
+		},
+		cli.StringFlag{
+			Name:  "after-each-migration",
+			Usage: "SQL to execute after each migration, in the same transaction",
+		},
+		cli.StringFlag{
+			Name:  "before-each-rollback",
+			Usage: "SQL to execute before each rollback, in the same transaction",
+		},
+		cli.StringFlag{
+			Name:  "after-each-rollback",
+			Usage: "SQL to execute after each rollback, in the same transaction",
+		},
+		cli.BoolFlag{
+			Name:  "advisory-lock",
+			Usage: "hold a database lock while migrating or rolling back, so that concurrent runs wait for each other",
+		},
+		cli.DurationFlag{
+			Name:  "lock-timeout",
+			Usage: "maximum time to wait for the lock taken by --advisory-lock (default: wait indefinitely)",
+		},
+		cli.BoolFlag{
+			Name:  "strict-ordering",
+			Usage: "refuse to apply pending migrations older than the latest applied migration",
+		},
+		cli.BoolFlag{
+			Name:  "require-down",
+			Usage: "refuse to apply migrations with an empty down block, unless declared irreversible",
+		},
+		cli.BoolFlag{
+			Name:  "confirm-data-loss",
+			Usage: "allow rolling back migrations whose down block is declared with data_loss:true",
+		},
+		cli.BoolFlag{
+			Name:  "continue-on-error",
+			Usage: "continue applying migrations after one fails, and report every failure at the end",
+		},
+		cli.BoolFlag{
+			Name:  "verbose",
+			Usage: "log the engine executing each migration, and each statement executed by the dbmate engine",
+		},
+		cli.StringFlag{
+			Name:  "log-format",
+			Value: "text",
+			Usage: "format of the messages written to stdout (text or json)",
+		},
+		cli.BoolFlag{
+			Name:  "trace-sql",
+			Usage: "log every statement executed during migrate/rollback along with its duration",
+		},
+		cli.BoolFlag{
+			Name:  "auto-detect-concurrent",
+			Usage: "run migrations using CONCURRENTLY (PostgreSQL) outside of a transaction",
+		},
+		cli.StringFlag{
+			Name:  "audit-table",
+			Usage: "record every applied and rolled back migration in this append-only table",
+		},
 	}
 
 	app.Commands = []cli.Command{
@@ -66,9 +220,19 @@ func NewApp() *cli.App {
 			Name:    "new",
 			Aliases: []string{"n"},
 			Usage:   "Generate a new migration file",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "from-diff",
+					Usage: "fill the migration with the schema changes made since the schema file was written",
+				},
+			},
 			Action: action(func(db *dbmate.DB, c *cli.Context) error {
 				name := c.Args().First()
-				return db.NewMigration(name)
+				if c.Bool("from-diff") {
+					return db.NewMigrationFromDiff(name)
+				}
+				_, err := db.NewMigration(name)
+				return err
 			}),
 		},
 		{
@@ -92,10 +256,34 @@ func NewApp() *cli.App {
 				return db.Drop()
 			}),
 		},
+		{
+			Name:  "reset",
+			Usage: "Drop the database (if it exists), create it again and run all migrations",
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				return db.Reset()
+			}),
+		},
 		{
 			Name:  "migrate",
 			Usage: "Migrate to the latest version",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "batch-id",
+					Usage: "batch identifier to record with the applied migrations (implies --record-batches)",
+				},
+				cli.StringFlag{
+					Name:  "to",
+					Usage: "migrate up or down to this version, rolling back newer migrations",
+				},
+			},
 			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				if batch := c.String("batch-id"); batch != "" {
+					db.RecordBatches = true
+					db.BatchID = batch
+				}
+				if version := c.String("to"); version != "" {
+					return db.MigrateTo(version)
+				}
 				return db.Migrate()
 			}),
 		},
@@ -103,10 +291,62 @@ func NewApp() *cli.App {
 			Name:    "rollback",
 			Aliases: []string{"down"},
 			Usage:   "Rollback the most recent migration",
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "steps",
+					Value: 1,
+					Usage: "number of migrations to roll back, newest first",
+				},
+			},
 			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				if c.IsSet("steps") {
+					return db.RollbackN(c.Int("steps"))
+				}
 				return db.Rollback()
 			}),
 		},
+		{
+			Name:  "redo",
+			Usage: "Rollback the most recent migration and apply it again",
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				return db.Redo()
+			}),
+		},
+		{
+			Name:  "rollback-batch",
+			Usage: "Rollback every migration applied in a batch",
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				return db.RollbackBatch(c.Args().First())
+			}),
+		},
+		{
+			Name:  "export-timings",
+			Usage: "Write a CSV report of the applied migrations and their timings",
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				return db.ExportTimings(os.Stdout)
+			}),
+		},
+		{
+			Name:  "baseline",
+			Usage: "Mark migrations (up to an optional version) as applied without running them",
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				return db.Baseline(c.Args().First())
+			}),
+		},
+		{
+			Name:  "lock",
+			Usage: "Prevent migrations from being applied or rolled back until unlocked",
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				return db.LockMigrations()
+			}),
+		},
+		{
+			Name:  "unlock",
+			Usage: "Allow migrations to be applied or rolled back again",
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				return db.UnlockMigrations()
+			}),
+		},
 		{
 			Name:  "status",
 			Usage: "List applied and pending migrations",
@@ -119,6 +359,10 @@ func NewApp() *cli.App {
 					Name:  "quiet",
 					Usage: "don't output any text (implies --exit-code)",
 				},
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the status as JSON",
+				},
 			},
 			Action: action(func(db *dbmate.DB, c *cli.Context) error {
 				setExitCode := c.Bool("exit-code")
@@ -127,6 +371,13 @@ func NewApp() *cli.App {
 					setExitCode = true
 				}
 
+				if c.Bool("json") {
+					if setExitCode {
+						return fmt.Errorf("--json cannot be combined with --exit-code or --quiet")
+					}
+					return db.StatusJSON(os.Stdout)
+				}
+
 				pending, err := db.Status(quiet)
 				if err != nil {
 					return err
@@ -139,6 +390,58 @@ func NewApp() *cli.App {
 				return nil
 			}),
 		},
+		{
+			Name:  "verify",
+			Usage: "Check that no pending migration is older than the latest applied migration, and no applied migration was modified (with --validate-checksums)",
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				return db.Verify()
+			}),
+		},
+		{
+			Name:  "check",
+			Usage: "Run static checks on migration files and report every issue found",
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				issues, err := db.CheckMigrations()
+				if err != nil {
+					return err
+				}
+
+				for _, issue := range issues {
+					fmt.Println(issue)
+				}
+				if len(issues) > 0 {
+					return fmt.Errorf("found %d issues in migration files", len(issues))
+				}
+
+				return nil
+			}),
+		},
+		{
+			Name:  "check-permissions",
+			Usage: "Check that the database user has the privileges needed to apply migrations",
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				missing, err := db.CheckPermissions()
+				if err != nil {
+					return err
+				}
+
+				for _, privilege := range missing {
+					fmt.Printf("Missing privilege: %s\n", privilege)
+				}
+				if len(missing) > 0 {
+					return fmt.Errorf("missing %d privileges needed to apply migrations", len(missing))
+				}
+
+				return nil
+			}),
+		},
+		{
+			Name:  "teardown",
+			Usage: "Print a SQL script which rolls back every applied migration, newest first",
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				return db.TeardownScript(os.Stdout)
+			}),
+		},
 		{
 			Name:  "dump",
 			Usage: "Write the database schema to disk",
@@ -146,10 +449,26 @@ func NewApp() *cli.App {
 				return db.DumpSchema()
 			}),
 		},
+		{
+			Name:  "load",
+			Usage: "Load the schema file into the database, and mark the migrations it records as applied",
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				return db.LoadSchema()
+			}),
+		},
 		{
 			Name:  "wait",
 			Usage: "Wait for the database to become available",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "ready",
+					Usage: "also wait for the database to exist, not only the server",
+				},
+			},
 			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				if c.Bool("ready") {
+					return db.WaitReady()
+				}
 				return db.Wait()
 			}),
 		},
@@ -179,9 +498,64 @@ func action(f func(*dbmate.DB, *cli.Context) error) cli.ActionFunc {
 		db := dbmate.New(u)
 		db.AutoDumpSchema = !c.GlobalBool("no-dump-schema")
 		db.MigrationsDir = c.GlobalString("migrations-dir")
+		db.Recursive = c.GlobalBool("migrations-recursive")
+		if pattern := c.GlobalString("migrations-pattern"); pattern != "" {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return err
+			}
+			db.MigrationFilePattern = re
+		}
+		db.MigrationsTableName = c.GlobalString("migrations-table")
 		db.SchemaFile = c.GlobalString("schema-file")
+		db.DumpSchemaPerTable = c.GlobalBool("dump-schema-per-table")
+		db.NormalizeLineEndings = c.GlobalString("normalize-line-endings")
+		db.VersionFile = c.GlobalString("version-file")
 		db.WaitBefore = c.GlobalBool("wait")
+		for _, value := range c.GlobalStringSlice("wait-url") {
+			extra, err := url.Parse(value)
+			if err != nil {
+				return err
+			}
+			db.WaitExtraURLs = append(db.WaitExtraURLs, extra)
+		}
 		db.NativeEngine = !c.GlobalBool("dbmate-engine")
+		db.DryRun = c.GlobalBool("dry-run")
+		db.Strict = c.GlobalBool("strict")
+		db.TraceSQL = c.GlobalBool("trace-sql")
+		db.Verbose = c.GlobalBool("verbose")
+		switch format := c.GlobalString("log-format"); format {
+		case "text":
+		case "json":
+			db.Logger = dbmate.NewJSONLogger(os.Stdout, dbmate.LogDebug)
+		default:
+			return fmt.Errorf("unsupported log format: %s", format)
+		}
+		db.ValidateChecksums = c.GlobalBool("validate-checksums")
+		db.FailOnChecksumMismatch = c.GlobalBool("fail-on-checksum-mismatch")
+		db.ChecksumAlgo = c.GlobalString("checksum-algo")
+		db.RecordBatches = c.GlobalBool("record-batches")
+		db.RecordTimings = c.GlobalBool("record-timings")
+		db.CheckForeignKeys = c.GlobalBool("check-foreign-keys")
+		db.ContinueOnError = c.GlobalBool("continue-on-error")
+		db.RequireDown = c.GlobalBool("require-down")
+		db.StrictOrdering = c.GlobalBool("strict-ordering")
+		db.AdvisoryLock = c.GlobalBool("advisory-lock")
+		db.LockTimeout = c.GlobalDuration("lock-timeout")
+		db.ConfirmDataLoss = c.GlobalBool("confirm-data-loss")
+		db.DelayBetweenMigrations = c.GlobalDuration("delay-between-migrations")
+		db.MaxReplicaLag = c.GlobalDuration("max-replica-lag")
+		db.ReplicaLagInterval = c.GlobalDuration("replica-lag-interval")
+		db.ReplicaLagTimeout = c.GlobalDuration("replica-lag-timeout")
+		db.StatementTimeout = c.GlobalDuration("statement-timeout")
+		db.BeforeMigrateSQL = c.GlobalString("before-migrate-sql")
+		db.AfterMigrateSQL = c.GlobalString("after-migrate-sql")
+		db.BeforeEachMigration = c.GlobalString("before-each-migration")
+		db.AfterEachMigration = c.GlobalString("after-each-migration")
+		db.BeforeEachRollback = c.GlobalString("before-each-rollback")
+		db.AfterEachRollback = c.GlobalString("after-each-rollback")
+		db.AuditTableName = c.GlobalString("audit-table")
+		db.AutoDetectConcurrent = c.GlobalBool("auto-detect-concurrent")
 
 		return f(db, c)
 	}
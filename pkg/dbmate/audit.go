@@ -0,0 +1,66 @@
+package dbmate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// auditTableNameRegexp matches the table names accepted for DB.AuditTableName,
+// optionally qualified with a schema, so that they can be used unquoted
+var auditTableNameRegexp = regexp.MustCompile(`^[A-Za-z_]\w*(\.[A-Za-z_]\w*)?$`)
+
+// AuditRecord describes an attempt to apply or roll back a migration
+type AuditRecord struct {
+	Version string
+	// Direction is "up" when the migration was applied, "down" when it was rolled back
+	Direction string
+	Success   bool
+	Time      time.Time
+}
+
+// auditStore returns the driver as an AuditStore, or an error if it does not
+// support audit tables or AuditTableName is invalid
+func (db *DB) auditStore(drv Driver) (AuditStore, error) {
+	store, ok := drv.(AuditStore)
+	if !ok {
+		return nil, fmt.Errorf("audit tables are not supported by driver: %s",
+			db.DatabaseURL.Scheme)
+	}
+
+	if !auditTableNameRegexp.MatchString(db.AuditTableName) {
+		return nil, fmt.Errorf("invalid audit table name: %s", db.AuditTableName)
+	}
+
+	return store, nil
+}
+
+// auditMigration records the outcome of a migration in the audit table, if enabled.
+// Successful migrations are recorded by the same transaction as the migration itself.
+func (db *DB) auditMigration(drv Driver, tx Transaction, version, direction string, success bool) error {
+	if db.AuditTableName == "" {
+		return nil
+	}
+
+	// checked by openDatabaseForMigration
+	return drv.(AuditStore).InsertAuditRecord(tx, db.AuditTableName, AuditRecord{
+		Version:   version,
+		Direction: direction,
+		Success:   success,
+		Time:      time.Now().UTC(),
+	})
+}
+
+// auditFailure records a failed migration in the audit table, if enabled. The record
+// is written outside of the migration transaction, which has been rolled back, and
+// errors are only logged so that the migration error is reported.
+func (db *DB) auditFailure(drv Driver, sqlDB *sql.DB, version, direction string) {
+	// not bound to the migration context, so that canceled migrations are also recorded
+	err := db.auditMigration(drv, contextTransaction{ctx: context.Background(), tx: sqlDB},
+		version, direction, false)
+	if err != nil {
+		db.warnf("Unable to record failed migration in audit table: %s\n", err)
+	}
+}
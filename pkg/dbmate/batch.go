@@ -0,0 +1,62 @@
+package dbmate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// newBatchID returns a batch identifier derived from the current time
+func newBatchID() string {
+	return time.Now().UTC().Format("20060102150405.000000")
+}
+
+// batchStore returns the driver as a BatchStore, or an error if it does
+// not support recording migration batches
+func (db *DB) batchStore(drv Driver) (BatchStore, error) {
+	store, ok := drv.(BatchStore)
+	if !ok {
+		return nil, fmt.Errorf("migration batches are not supported by driver: %s",
+			db.DatabaseURL.Scheme)
+	}
+
+	return store, nil
+}
+
+// RollbackBatch rolls back every migration applied by the Migrate run which recorded
+// the given batch (see DB.RecordBatches), newest first. Like RollbackN, each migration
+// is rolled back separately, so if one of them fails, the migrations rolled back
+// before it remain rolled back.
+func (db *DB) RollbackBatch(batch string) error {
+	return db.RollbackBatchContext(context.Background(), batch)
+}
+
+// RollbackBatchContext is like RollbackBatch, but stops as soon as ctx is done
+func (db *DB) RollbackBatchContext(ctx context.Context, batch string) error {
+	if batch == "" {
+		return fmt.Errorf("please specify a batch to roll back")
+	}
+
+	_, err := db.rollbackVersions(ctx, db.AutoDumpSchema, func(drv Driver, sqlDB *sql.DB) ([]string, error) {
+		store, err := db.batchStore(drv)
+		if err != nil {
+			return nil, err
+		}
+
+		versions, err := store.SelectBatchMigrations(sqlDB, batch)
+		if err != nil {
+			return nil, err
+		}
+		if len(versions) == 0 {
+			return nil, fmt.Errorf("can't rollback: no migrations have been applied in batch `%s`", batch)
+		}
+
+		sort.Sort(sort.Reverse(sort.StringSlice(versions)))
+
+		return versions, nil
+	})
+
+	return err
+}
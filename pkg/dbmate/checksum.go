@@ -0,0 +1,158 @@
+package dbmate
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// Checksum algorithms accepted by DB.ChecksumAlgo
+const (
+	ChecksumSHA256 = "sha256"
+	ChecksumSHA384 = "sha384"
+	ChecksumSHA512 = "sha512"
+)
+
+// checksumAlgorithms maps the supported checksum algorithms to their hash functions
+var checksumAlgorithms = map[string]func() hash.Hash{
+	ChecksumSHA256: sha256.New,
+	ChecksumSHA384: sha512.New384,
+	ChecksumSHA512: sha512.New,
+}
+
+// checksumAlgo returns ChecksumAlgo, or ChecksumSHA256 when it is empty, and an error
+// if the algorithm is not supported
+func (db *DB) checksumAlgo() (string, error) {
+	algo := db.ChecksumAlgo
+	if algo == "" {
+		algo = ChecksumSHA256
+	}
+
+	if _, ok := checksumAlgorithms[algo]; !ok {
+		return "", fmt.Errorf("unsupported checksum algorithm `%s`", algo)
+	}
+
+	return algo, nil
+}
+
+// migrationChecksum returns the checksum of the contents of a migration (see
+// migrationContents). Checksums other
+// than SHA-256 are prefixed with their algorithm (e.g. `sha512:...`), so that each
+// recorded checksum can be verified with the algorithm it was computed with.
+func migrationChecksum(algo, contents string) string {
+	h := checksumAlgorithms[algo]()
+	h.Write([]byte(contents)) // nolint:errcheck
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	if algo == ChecksumSHA256 {
+		return sum
+	}
+
+	return algo + ":" + sum
+}
+
+// migrationContents returns the contents of both blocks of a migration, so that
+// modifying either of them changes its checksum
+func migrationContents(up, down Migration) string {
+	return up.Contents + down.Contents
+}
+
+// checksumMatches reports whether contents match a recorded checksum, using the
+// algorithm of the checksum (SHA-256 for checksums without a prefix)
+func checksumMatches(checksum, contents string) (bool, error) {
+	algo := ChecksumSHA256
+	if i := strings.Index(checksum, ":"); i >= 0 {
+		algo = checksum[:i]
+	}
+
+	if _, ok := checksumAlgorithms[algo]; !ok {
+		return false, fmt.Errorf("unsupported checksum algorithm `%s`", algo)
+	}
+
+	return migrationChecksum(algo, contents) == checksum, nil
+}
+
+// checksumStore returns the driver as a ChecksumStore, or an error if it does
+// not support storing migration checksums
+func (db *DB) checksumStore(drv Driver) (ChecksumStore, error) {
+	store, ok := drv.(ChecksumStore)
+	if !ok {
+		return nil, fmt.Errorf("checksum validation is not supported by driver: %s",
+			db.DatabaseURL.Scheme)
+	}
+
+	return store, nil
+}
+
+// validateChecksums reports whether migration checksums are recorded and validated,
+// which FailOnChecksumMismatch implies
+func (db *DB) validateChecksums() bool {
+	return db.ValidateChecksums || db.FailOnChecksumMismatch
+}
+
+// modifiedMigrations returns the versions of applied migration files whose contents
+// no longer match the checksum recorded when they were applied. Migrations applied
+// without a checksum (e.g. before ValidateChecksums was enabled) are not checked.
+func (db *DB) modifiedMigrations(drv Driver, sqlDB *sql.DB, files []string) (map[string]bool, error) {
+	store, err := db.checksumStore(drv)
+	if err != nil {
+		return nil, err
+	}
+
+	checksums, err := store.SelectMigrationChecksums(sqlDB)
+	if err != nil && db.DryRun {
+		// the checksum column may not exist yet
+		return map[string]bool{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	modified := map[string]bool{}
+	for _, filename := range files {
+		ver := migrationVersion(filename)
+		checksum, ok := checksums[ver]
+		if !ok || isGoMigration(filename) {
+			continue
+		}
+
+		up, down, err := db.loadMigration(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		matches, err := checksumMatches(checksum, migrationContents(up, down))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", filename, err)
+		}
+		if !matches {
+			modified[ver] = true
+		}
+	}
+
+	return modified, nil
+}
+
+// selectMigrationChecksums returns the checksums returned by a (version, checksum) query
+func selectMigrationChecksums(db *sql.DB, query string) (map[string]string, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer mustClose(rows)
+
+	checksums := map[string]string{}
+	for rows.Next() {
+		var version, checksum string
+		if err := rows.Scan(&version, &checksum); err != nil {
+			return nil, err
+		}
+
+		checksums[version] = checksum
+	}
+
+	return checksums, rows.Err()
+}
@@ -0,0 +1,89 @@
+package dbmate
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// CompareEnvironments reports, for each named environment, the migration versions
+// which have been applied in at least one other environment but are missing there.
+// Environments are only read from, the migrations table is never created.
+//
+// Environments which cannot be reached (or which have no migrations table) are
+// left out of the result and of the comparison. In that case the result for the
+// remaining environments is returned along with an error naming each failure.
+func CompareEnvironments(urls map[string]*url.URL) (map[string][]string, error) {
+	return CompareEnvironmentsContext(context.Background(), urls)
+}
+
+// CompareEnvironmentsContext is like CompareEnvironments, but gives up as soon as ctx
+// is done, returning its error rather than reporting the remaining environments as
+// unreachable
+func CompareEnvironmentsContext(ctx context.Context, urls map[string]*url.URL) (map[string][]string, error) {
+	names := make([]string, 0, len(urls))
+	for name := range urls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	applied := map[string]map[string]bool{}
+	all := map[string]bool{}
+	failures := []string{}
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		versions, err := appliedVersions(ctx, urls[name])
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", name, err))
+			continue
+		}
+
+		applied[name] = versions
+		for ver := range versions {
+			all[ver] = true
+		}
+	}
+
+	result := map[string][]string{}
+	for name, versions := range applied {
+		missing := []string{}
+		for ver := range all {
+			if !versions[ver] {
+				missing = append(missing, ver)
+			}
+		}
+		sort.Strings(missing)
+		result[name] = missing
+	}
+
+	if len(failures) > 0 {
+		return result, fmt.Errorf("unable to compare environments: %s", strings.Join(failures, "; "))
+	}
+
+	return result, nil
+}
+
+// appliedVersions returns the set of migration versions applied to a database
+func appliedVersions(ctx context.Context, u *url.URL) (map[string]bool, error) {
+	drv, err := GetDriver(u.Scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := drv.Open(u)
+	if err != nil {
+		return nil, err
+	}
+	defer mustClose(sqlDB)
+
+	if err := sqlDB.PingContext(ctx); err != nil {
+		return nil, err
+	}
+
+	return selectAppliedMigrations(drv, sqlDB, -1)
+}
@@ -1,16 +1,23 @@
 package dbmate
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"io/fs"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"sort"
 	"strings"
-	"text/scanner"
+	"sync"
 	"time"
 )
 
@@ -26,26 +33,284 @@ const DefaultWaitInterval = time.Second
 // DefaultWaitTimeout specifies maximum time for connection attempts
 const DefaultWaitTimeout = 60 * time.Second
 
-const endOfStatement = ';'
+// DefaultFileMode specifies the permissions of migration and schema files
+const DefaultFileMode os.FileMode = 0644
+
+// DefaultDirMode specifies the permissions of directories created by dbmate
+const DefaultDirMode os.FileMode = 0755
+
+// DefaultReplicaLagInterval specifies length of time between replication lag checks
+const DefaultReplicaLagInterval = time.Second
+
+// DefaultReplicaLagTimeout specifies maximum time to wait for replicas to catch up
+const DefaultReplicaLagTimeout = 10 * time.Minute
 
 // DB allows dbmate actions to be performed on a specified database
 type DB struct {
 	AutoDumpSchema bool
 	DatabaseURL    *url.URL
-	MigrationsDir  string
-	SchemaFile     string
-	WaitBefore     bool
-	WaitInterval   time.Duration
-	WaitTimeout    time.Duration
-	NativeEngine   bool
+	// MigrationsDir is the directory of the migration files. It may list several
+	// directories separated by the OS path list separator (`:` on Unix, `;` on
+	// Windows), e.g. `./db/migrations:./vendor/module/migrations`: their migrations are
+	// merged and ordered by version. New migrations are created in the first one.
+	MigrationsDir string
+	SchemaFile    string
+	WaitBefore    bool
+	WaitInterval  time.Duration
+	WaitTimeout   time.Duration
+	// PasswordFunc, when set, is called each time dbmate connects to the database, and
+	// returns the password to connect with (e.g. from a secrets manager, so that it can
+	// rotate). DatabaseURL should not include a password: it is only set on a copy of
+	// DatabaseURL, so that DatabaseURL can be logged safely.
+	PasswordFunc func() (string, error)
+	// WaitExtraURLs are additional database servers (e.g. read replicas) which Wait
+	// also waits for. All servers are pinged concurrently, and must become available
+	// within a single WaitTimeout.
+	WaitExtraURLs []*url.URL
+	NativeEngine  bool
+	TraceSQL      bool
+	// Verbose logs the engine executing each migration script, and with the DBMate
+	// engine, each statement as it is executed. By default only the migration files
+	// being applied or rolled back are logged.
+	Verbose bool
+	// Log receives progress messages, defaults to os.Stdout. Set it to
+	// ioutil.Discard to run silently.
+	Log io.Writer
+	// Logger, when set, receives the messages instead of Log, along with their level,
+	// e.g. to route them to an application logger. See NewTextLogger and
+	// NewJSONLogger.
+	Logger Logger
+	// MinFreeBytes, when non-zero, is the free disk space required before applying
+	// each migration, for drivers storing the database locally (SQLite)
+	MinFreeBytes uint64
+	// DryRun makes Migrate and Rollback print the scripts they would execute
+	// without modifying the database
+	DryRun bool
+	// Strict enables static checks of migration files before they are applied, which
+	// log warnings, and fail the migration if the file is not valid UTF-8
+	Strict bool
+	// VersionFile, when set, is updated with the current migration version
+	// after each migrate or rollback
+	VersionFile string
+	// SchemaValidate, when set, is called with the dumped schema before it is
+	// written to SchemaFile. Returning an error fails the dump.
+	SchemaValidate func([]byte) error
+	// SchemaPrologue and SchemaEpilogue are written before and after the schema dumped
+	// by the driver (e.g. a license header, or session settings), whether the schema
+	// is dumped explicitly or after a migrate or rollback. The driver marker stays on
+	// the first line. They are only written to SQL schema files.
+	SchemaPrologue string
+	SchemaEpilogue string
+	// NormalizeLineEndings, when set to LineEndingsLF or LineEndingsCRLF, converts the
+	// line endings of dumped schemas, and ensures that they end with exactly one
+	// newline, so that the schema file does not change across platforms
+	NormalizeLineEndings string
+	// DumpSchemaPerTable writes the schema of each table to its own file, in a
+	// directory named after SchemaFile without its extension (e.g. `db/schema/users.sql`
+	// for `db/schema.sql`), instead of a single schema file
+	DumpSchemaPerTable bool
+	// ValidateChecksums records a checksum of each migration when it is applied, and
+	// warns about applied migrations whose file has since been modified. It adds a
+	// checksum column to the migrations table.
+	ValidateChecksums bool
+	// FailOnChecksumMismatch makes Migrate fail, before applying any migration, when
+	// ValidateChecksums finds applied migrations whose file has since been modified,
+	// instead of warning about them. It implies ValidateChecksums.
+	FailOnChecksumMismatch bool
+	// ChecksumAlgo is the algorithm of the checksums recorded by ValidateChecksums:
+	// ChecksumSHA256 (the default when empty), ChecksumSHA384 or ChecksumSHA512.
+	// Each recorded checksum is verified with the algorithm it was computed with.
+	ChecksumAlgo string
+	// RecordBatches records a batch identifier with each migration applied by Migrate,
+	// so that all the migrations applied by a single run can be rolled back together
+	// with RollbackBatch. The identifier is BatchID, or is generated from the current
+	// time when BatchID is empty. It adds a batch column to the migrations table.
+	RecordBatches bool
+	BatchID       string
+	// RecordTimings records when each migration applied by Migrate was applied, and
+	// how long it took, for ExportTimings. It adds applied_at and duration_ms columns
+	// to the migrations table.
+	RecordTimings bool
+	// AfterMigrateComplete, when set, is called once at the end of each Migrate run
+	// (successful or not) with a summary of the migrations applied. It is not
+	// called during a dry run.
+	AfterMigrateComplete func(summary MigrateSummary)
+	// OnEvent, when set, is called at each step of a migration run (waiting for the
+	// database, applying or rolling back a migration, dumping the schema), e.g. to
+	// report progress to a structured logger or an admin UI. Progress messages are
+	// still written to Log. It may be called concurrently by MigrateDatabases.
+	OnEvent func(event Event)
+	// ContinueOnError makes Migrate log a failed migration and proceed with the
+	// next one, instead of stopping. Failed migrations are not recorded, and an
+	// error listing every failed version is returned once all files have been
+	// attempted. A failed non-transactional migration may be partially applied.
+	ContinueOnError bool
+	// MigrationTemplate, when non-empty, is written to new migration files instead
+	// of the default template. It must still contain the `-- migrate:up` and
+	// `-- migrate:down` markers so that the migration can be parsed.
+	MigrationTemplate string
+	// DelayBetweenMigrations, when non-zero, makes Migrate pause between applying
+	// two migrations, e.g. to let replicas catch up after heavy migrations
+	DelayBetweenMigrations time.Duration
+	// MaxReplicaLag, when non-zero, makes Migrate wait after applying each migration
+	// until the replication lag reported by the driver drops below it, checking every
+	// ReplicaLagInterval. Migrate fails if the lag is still too high after ReplicaLagTimeout.
+	MaxReplicaLag      time.Duration
+	ReplicaLagInterval time.Duration
+	ReplicaLagTimeout  time.Duration
+	// BeforeMigrateSQL, when set, is a statement executed once by Migrate before
+	// applying migrations (outside of any migration transaction), e.g. to enable an
+	// application maintenance flag. Migrate fails without applying anything if it fails.
+	BeforeMigrateSQL string
+	// AfterMigrateSQL, when set, is a statement executed once by Migrate after
+	// applying migrations, even if a migration failed. Its failure is reported by
+	// the error returned by Migrate, but does not undo the applied migrations.
+	AfterMigrateSQL string
+	// BeforeEachMigration and AfterEachMigration, when set, are SQL scripts executed
+	// before and after the up block of each migration, in the same transaction (e.g.
+	// `SET lock_timeout = '5s'` and `ANALYZE`). BeforeEachRollback and AfterEachRollback
+	// are their counterparts for down blocks. A failed script fails the migration.
+	BeforeEachMigration string
+	AfterEachMigration  string
+	BeforeEachRollback  string
+	AfterEachRollback   string
+	// RequireDown makes Migrate fail before applying a migration file whose down
+	// block is missing or empty, unless the down block is declared irreversible with
+	// `-- migrate:down irreversible:true`. Go migrations are not checked.
+	RequireDown bool
+	// ConfirmDataLoss allows rolling back migrations whose down block is declared with
+	// `-- migrate:down data_loss:true`, because it cannot restore the data removed by
+	// the up block. Without it, rollbacks including such a migration fail before
+	// rolling anything back.
+	ConfirmDataLoss bool
+	// StrictOrdering makes Migrate fail before applying anything if a pending
+	// migration has a lower version than the latest applied migration, which
+	// typically happens when a branch with an older migration is merged.
+	StrictOrdering bool
+	// DefaultTxOptions, when set, are used for the transaction of every migration
+	// run inside a transaction (during migrate and rollback). The isolation option of
+	// a migration (e.g. `-- migrate:up isolation:serializable`) takes precedence.
+	// Isolation levels which are not supported by the driver fail the migration.
+	DefaultTxOptions *sql.TxOptions
+	// AdvisoryLock makes Migrate and Rollback hold a database lock (a PostgreSQL
+	// advisory lock, or a MySQL named lock) of the migrations table while they run,
+	// so that concurrent runs, e.g. of several application instances starting at
+	// once, wait for each other instead of racing to apply the same migrations
+	AdvisoryLock bool
+	// LockTimeout is how long to wait for the advisory lock. Zero waits until the
+	// context is done.
+	LockTimeout time.Duration
+	// MigrationsFS, when set, is the filesystem which migration files are read
+	// from (e.g. an embed.FS), and MigrationsDir is the path of the migrations
+	// within it. NewMigration still creates files in MigrationsDir within FS.
+	MigrationsFS fs.FS
+	// FS, when set, is the filesystem which migration, schema and version files are
+	// read from and written to, instead of the local filesystem (e.g. an in-memory
+	// filesystem in tests). Database files, such as SQLite databases, are not
+	// affected.
+	FS FileSystem
+	// CheckForeignKeys makes Migrate verify, once it has applied migrations, that
+	// existing rows satisfy the foreign key constraints of the database (which data
+	// migrations may have broken, e.g. with checks disabled), and fail if they do not
+	CheckForeignKeys bool
+	// FileMode and DirMode are the permissions of the migration, schema and version
+	// files, and of the directories created for them. FileMode is applied exactly,
+	// regardless of the umask, including to existing files which are rewritten.
+	// DirMode only applies to new directories, subject to the umask. They default to
+	// DefaultFileMode and DefaultDirMode when zero.
+	FileMode os.FileMode
+	DirMode  os.FileMode
+	// CreateMigrationsTableFunc, when set, is used instead of the driver
+	// to create the migrations table
+	CreateMigrationsTableFunc func(*sql.DB) error
+	// AuditTableName, when set, is the name of an append-only table (e.g.
+	// schema_migrations_audit) recording every attempt to apply or roll back a
+	// migration, with its version, direction, outcome and time. Unlike the
+	// migrations table, it keeps the history of rolled back migrations.
+	AuditTableName string
+	// AutoDetectConcurrent runs migrations outside of a transaction, even without the
+	// `transaction:false` option, when they contain a statement which the driver
+	// reports cannot run inside a transaction (CONCURRENTLY operations on PostgreSQL).
+	// A warning is logged for each migration affected.
+	AutoDetectConcurrent bool
+	// MigrationsTableName is the table recording applied migrations, schema_migrations
+	// when empty. It may be qualified with a schema (e.g. `app.schema_migrations`),
+	// which must already exist.
+	MigrationsTableName string
+	// MaxConcurrency is the maximum number of databases which MigrateDatabases
+	// migrates at once, e.g. to avoid overwhelming a shared connection pooler.
+	// All databases are migrated at once when it is zero.
+	MaxConcurrency int
+	// StatementTimeout, when non-zero, cancels each statement of a migration script which
+	// runs longer than it, and fails the migration with an error naming the statement.
+	// With the native engine, the whole script is a single statement.
+	StatementTimeout time.Duration
+	// MigrationFilePattern matches the names of migration files, `^\d.*\.sql$` when nil.
+	// Matching names must still start with the version of the migration.
+	MigrationFilePattern *regexp.Regexp
+	// Recursive makes migration files in subdirectories of MigrationsDir (e.g. one
+	// directory per year) be found too. Migrations are ordered by version across all
+	// directories, and their names include the path relative to MigrationsDir.
+	Recursive bool
+
+	// goMigrations holds the Go migrations registered by DB.RegisterGoMigration
+	goMigrations map[string]goMigration
+	// now returns the current time, from which new migration versions are derived
+	now func() time.Time
 }
 
 // migrationFileRegexp pattern for valid migration files
 var migrationFileRegexp = regexp.MustCompile(`^\d.*\.sql$`)
 
-type statusResult struct {
-	filename string
-	applied  bool
+// migrationFilePattern returns MigrationFilePattern, or migrationFileRegexp if it is nil
+func (db *DB) migrationFilePattern() *regexp.Regexp {
+	if db.MigrationFilePattern != nil {
+		return db.MigrationFilePattern
+	}
+
+	return migrationFileRegexp
+}
+
+// MigrationStatus describes whether a migration file has been applied.
+// Orphaned migrations have been applied, but no longer have a migration file.
+type MigrationStatus struct {
+	Version  string `json:"version"`
+	Filename string `json:"filename"`
+	Applied  bool   `json:"applied"`
+	Orphaned bool   `json:"orphaned"`
+	// Modified is only reported when ValidateChecksums is enabled
+	Modified bool `json:"modified"`
+	// AppliedAt is when the migration was applied, if it was recorded (see
+	// RecordTimings). It is only reported when RecordTimings is enabled.
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// MigrateSummary describes the outcome of a Migrate run
+type MigrateSummary struct {
+	// Migrations lists the migrations applied, in order
+	Migrations []MigrationTiming
+	Duration   time.Duration
+	// Err is the error which stopped the run, or nil if it was successful
+	Err error
+	// BatchID is the batch recorded with the migrations, when RecordBatches is enabled
+	BatchID string
+}
+
+// versions returns the versions of the migrations applied, in order
+func (s MigrateSummary) versions() []string {
+	versions := make([]string, 0, len(s.Migrations))
+	for _, m := range s.Migrations {
+		versions = append(versions, m.Version)
+	}
+
+	return versions
+}
+
+// MigrationTiming records how long a migration took to apply
+type MigrationTiming struct {
+	Version  string
+	Filename string
+	Duration time.Duration
 }
 
 // New initializes a new dbmate database
@@ -59,52 +324,302 @@ func New(databaseURL *url.URL) *DB {
 		WaitInterval:   DefaultWaitInterval,
 		WaitTimeout:    DefaultWaitTimeout,
 		NativeEngine:   true,
+		Log:            os.Stdout,
+
+		ReplicaLagInterval: DefaultReplicaLagInterval,
+		ReplicaLagTimeout:  DefaultReplicaLagTimeout,
+		FileMode:           DefaultFileMode,
+		DirMode:            DefaultDirMode,
 	}
 }
 
+// fileMode returns the permissions of files written by dbmate
+func (db *DB) fileMode() os.FileMode {
+	if db.FileMode == 0 {
+		return DefaultFileMode
+	}
+
+	return db.FileMode
+}
+
+// dirMode returns the permissions of directories created by dbmate
+func (db *DB) dirMode() os.FileMode {
+	if db.DirMode == 0 {
+		return DefaultDirMode
+	}
+
+	return db.DirMode
+}
+
+// timeNow returns the current time, using now when it is set
+func (db *DB) timeNow() time.Time {
+	if db.now == nil {
+		return time.Now()
+	}
+
+	return db.now()
+}
+
 // GetDriver loads the required database driver
 func (db *DB) GetDriver() (Driver, error) {
-	return GetDriver(db.DatabaseURL.Scheme)
+	drv, err := GetDriver(db.DatabaseURL.Scheme)
+	if err != nil || db.MigrationsTableName == "" {
+		return drv, err
+	}
+
+	renamer, ok := drv.(MigrationsTableRenamer)
+	if !ok {
+		return nil, fmt.Errorf("custom migrations tables are not supported by driver: %s",
+			db.DatabaseURL.Scheme)
+	}
+
+	if !migrationsTableNameRegexp.MatchString(db.MigrationsTableName) {
+		return nil, fmt.Errorf("invalid migrations table name: %s", db.MigrationsTableName)
+	}
+
+	return renamer.WithMigrationsTable(db.MigrationsTableName), nil
+}
+
+// connectionURL returns the URL used to connect to the database: DatabaseURL, or a copy
+// of it holding the password returned by PasswordFunc, so that DatabaseURL never does
+func (db *DB) connectionURL() (*url.URL, error) {
+	if db.PasswordFunc == nil {
+		return db.DatabaseURL, nil
+	}
+
+	password, err := db.PasswordFunc()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get database password: %s", err)
+	}
+
+	u := *db.DatabaseURL
+	u.User = url.UserPassword(db.DatabaseURL.User.Username(), password)
+
+	return &u, nil
+}
+
+// databaseName returns the name of the database at DatabaseURL, for progress messages
+func (db *DB) databaseName(drv Driver) string {
+	if namer, ok := drv.(databaseNamer); ok {
+		return namer.databaseName(db.DatabaseURL)
+	}
+
+	return databaseName(db.DatabaseURL)
 }
 
 // Wait blocks until the database server is available. It does not verify that
 // the specified database exists, only that the host is ready to accept connections.
 func (db *DB) Wait() error {
+	return db.WaitContext(context.Background())
+}
+
+// WaitContext is like Wait, but gives up as soon as ctx is done
+func (db *DB) WaitContext(ctx context.Context) error {
+	if len(db.WaitExtraURLs) > 0 {
+		return db.waitAll(ctx)
+	}
+
+	drv, err := db.GetDriver()
+	if err != nil {
+		return err
+	}
+
+	u, err := db.connectionURL()
+	if err != nil {
+		return err
+	}
+
+	err = db.waitFor(ctx, func() error {
+		// attempt connection to database server
+		return pingContext(ctx, drv, u)
+	})
+	if err != nil && err != ctx.Err() {
+		return fmt.Errorf("unable to connect to database: %s", err)
+	}
+
+	return err
+}
+
+// waitAll pings DatabaseURL and WaitExtraURLs concurrently until each of them is
+// available. The servers share a single WaitTimeout budget, and the error names
+// every host which never came up.
+func (db *DB) waitAll(ctx context.Context) error {
+	u, err := db.connectionURL()
+	if err != nil {
+		return err
+	}
+
+	urls := append([]*url.URL{u}, db.WaitExtraURLs...)
+	drvs := make([]Driver, len(urls))
+	for i, u := range urls {
+		drv, err := GetDriver(u.Scheme)
+		if err != nil {
+			return err
+		}
+		drvs[i] = drv
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, db.WaitTimeout)
+	defer cancel()
+
+	errs := make([]error, len(urls))
+	var wg sync.WaitGroup
+	for i := range urls {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for {
+				errs[i] = pingContext(waitCtx, drvs[i], urls[i])
+				if errs[i] == nil {
+					return
+				}
+
+				select {
+				case <-waitCtx.Done():
+					return
+				case <-time.After(db.WaitInterval):
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	failed := []string{}
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s (%s)", urlHost(drvs[i], urls[i]), err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("unable to connect to %d of %d databases: %s", len(failed), len(urls),
+			strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
+// urlHost returns the host of a database URL, or the database name for databases
+// without a host (e.g. the path of SQLite databases)
+func urlHost(drv Driver, u *url.URL) string {
+	if u.Host != "" {
+		return u.Host
+	}
+
+	if namer, ok := drv.(databaseNamer); ok {
+		return namer.databaseName(u)
+	}
+
+	return databaseName(u)
+}
+
+// errDatabaseMissing is returned by databaseReady when the server is available,
+// but the database does not exist
+var errDatabaseMissing = errors.New("database does not exist")
+
+// WaitReady blocks until the database exists and accepts connections. Unlike Wait,
+// which only waits for the server, the next steps can safely query the database.
+func (db *DB) WaitReady() error {
+	return db.WaitReadyContext(context.Background())
+}
+
+// WaitReadyContext is like WaitReady, but gives up as soon as ctx is done
+func (db *DB) WaitReadyContext(ctx context.Context) error {
 	drv, err := db.GetDriver()
 	if err != nil {
 		return err
 	}
 
-	// attempt connection to database server
-	err = drv.Ping(db.DatabaseURL)
+	u, err := db.connectionURL()
+	if err != nil {
+		return err
+	}
+
+	err = db.waitFor(ctx, func() error {
+		return databaseReady(ctx, drv, u)
+	})
+	if err == errDatabaseMissing {
+		return fmt.Errorf("the database server is available, but database `%s` does not exist",
+			db.databaseName(drv))
+	} else if err != nil && err != ctx.Err() {
+		return fmt.Errorf("unable to connect to database: %s", err)
+	}
+
+	return err
+}
+
+// databaseReady returns nil if the database exists and accepts connections
+func databaseReady(ctx context.Context, drv Driver, u *url.URL) error {
+	// pinging sqlite would create the database
+	if drv.Capabilities().ServerAccess {
+		if err := pingContext(ctx, drv, u); err != nil {
+			return err
+		}
+	}
+
+	exists, err := drv.DatabaseExists(u)
+	if err == nil && !exists {
+		return errDatabaseMissing
+	} else if err == nil {
+		return nil
+	}
+
+	// checking whether the database exists may require server level privileges,
+	// connect to the database itself instead
+	sqlDB, err := drv.Open(u)
+	if err != nil {
+		return err
+	}
+	defer mustClose(sqlDB)
+
+	return sqlDB.PingContext(ctx)
+}
+
+// waitFor calls check until it succeeds, every WaitInterval up to WaitTimeout, and
+// returns the last error. It returns ctx.Err() as soon as ctx is done.
+func (db *DB) waitFor(ctx context.Context, check func() error) error {
+	err := check()
 	if err == nil {
 		// connection successful
 		return nil
 	}
 
-	fmt.Print("Waiting for database")
+	db.logf("Waiting for database")
+	db.emit(Event{Type: EventWaiting})
 	for i := 0 * time.Second; i < db.WaitTimeout; i += db.WaitInterval {
-		fmt.Print(".")
-		time.Sleep(db.WaitInterval)
+		db.logf(".")
+		select {
+		case <-ctx.Done():
+			db.logf("\n")
+			return ctx.Err()
+		case <-time.After(db.WaitInterval):
+		}
 
-		// attempt connection to database server
-		err = drv.Ping(db.DatabaseURL)
+		err = check()
 		if err == nil {
 			// connection successful
-			fmt.Print("\n")
+			db.logf("\n")
 			return nil
 		}
 	}
 
 	// if we find outselves here, we could not connect within the timeout
-	fmt.Print("\n")
-	return fmt.Errorf("unable to connect to database: %s", err)
+	db.logf("\n")
+	return err
 }
 
 // CreateAndMigrate creates the database (if necessary) and runs migrations
 func (db *DB) CreateAndMigrate() error {
+	return db.CreateAndMigrateContext(context.Background())
+}
+
+// CreateAndMigrateContext is like CreateAndMigrate, but stops as soon as ctx is done
+func (db *DB) CreateAndMigrateContext(ctx context.Context) error {
 	if db.WaitBefore {
-		err := db.Wait()
+		err := db.WaitContext(ctx)
 		if err != nil {
 			return err
 		}
@@ -115,24 +630,40 @@ func (db *DB) CreateAndMigrate() error {
 		return err
 	}
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	u, err := db.connectionURL()
+	if err != nil {
+		return err
+	}
+
 	// create database if it does not already exist
 	// skip this step if we cannot determine status
 	// (e.g. user does not have list database permission)
-	exists, err := drv.DatabaseExists(db.DatabaseURL)
+	exists, err := drv.DatabaseExists(u)
 	if err == nil && !exists {
-		if err := drv.CreateDatabase(db.DatabaseURL); err != nil {
+		db.logf("Creating: %s\n", db.databaseName(drv))
+		if err := drv.CreateDatabase(u); err != nil {
 			return err
 		}
 	}
 
 	// migrate
-	return db.Migrate()
+	_, err = db.migrate(ctx, nil, db.AutoDumpSchema)
+	return err
 }
 
 // Create creates the current database
 func (db *DB) Create() error {
+	return db.CreateContext(context.Background())
+}
+
+// CreateContext is like Create, but gives up as soon as ctx is done
+func (db *DB) CreateContext(ctx context.Context) error {
 	if db.WaitBefore {
-		err := db.Wait()
+		err := db.WaitContext(ctx)
 		if err != nil {
 			return err
 		}
@@ -143,13 +674,29 @@ func (db *DB) Create() error {
 		return err
 	}
 
-	return drv.CreateDatabase(db.DatabaseURL)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	u, err := db.connectionURL()
+	if err != nil {
+		return err
+	}
+
+	db.logf("Creating: %s\n", db.databaseName(drv))
+
+	return drv.CreateDatabase(u)
 }
 
 // Drop drops the current database (if it exists)
 func (db *DB) Drop() error {
+	return db.DropContext(context.Background())
+}
+
+// DropContext is like Drop, but gives up as soon as ctx is done
+func (db *DB) DropContext(ctx context.Context) error {
 	if db.WaitBefore {
-		err := db.Wait()
+		err := db.WaitContext(ctx)
 		if err != nil {
 			return err
 		}
@@ -160,371 +707,1889 @@ func (db *DB) Drop() error {
 		return err
 	}
 
-	return drv.DropDatabase(db.DatabaseURL)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	u, err := db.connectionURL()
+	if err != nil {
+		return err
+	}
+
+	db.logf("Dropping: %s\n", db.databaseName(drv))
+
+	return drv.DropDatabase(u)
 }
 
-// DumpSchema writes the current database schema to a file
-func (db *DB) DumpSchema() error {
+// Reset drops the current database (if it exists), creates it again, and runs all
+// migrations. With AutoDumpSchema, the schema file is written once at the end.
+func (db *DB) Reset() error {
+	return db.ResetContext(context.Background())
+}
+
+// ResetContext is like Reset, but stops as soon as ctx is done
+func (db *DB) ResetContext(ctx context.Context) error {
 	if db.WaitBefore {
-		err := db.Wait()
+		err := db.WaitContext(ctx)
 		if err != nil {
 			return err
 		}
 	}
 
-	drv, sqlDB, err := db.openDatabaseForMigration()
+	drv, err := db.GetDriver()
 	if err != nil {
 		return err
 	}
-	defer mustClose(sqlDB)
 
-	schema, err := drv.DumpSchema(db.DatabaseURL, sqlDB)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	u, err := db.connectionURL()
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("Writing: %s\n", db.SchemaFile)
+	// skip dropping a database which does not exist, since some drivers fail to
+	// drop it, but try anyway if we cannot determine status
+	exists, err := drv.DatabaseExists(u)
+	if err != nil || exists {
+		db.logf("Dropping: %s\n", db.databaseName(drv))
+		if err := drv.DropDatabase(u); err != nil {
+			return err
+		}
+	}
 
-	// ensure schema directory exists
-	if err = ensureDir(filepath.Dir(db.SchemaFile)); err != nil {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
 
-	// write schema to file
-	return ioutil.WriteFile(db.SchemaFile, schema, 0644)
+	db.logf("Creating: %s\n", db.databaseName(drv))
+	if err := drv.CreateDatabase(u); err != nil {
+		return err
+	}
+
+	_, err = db.migrate(ctx, nil, db.AutoDumpSchema)
+	return err
 }
 
-const migrationTemplate = "-- migrate:up\n\n\n-- migrate:down\n\n"
+// EnsureMigrationsTable creates the migrations table (if it does not already exist)
+// without running any migrations
+func (db *DB) EnsureMigrationsTable() error {
+	return db.EnsureMigrationsTableContext(context.Background())
+}
 
-// NewMigration creates a new migration file
-func (db *DB) NewMigration(name string) error {
-	// new migration name
-	timestamp := time.Now().UTC().Format("20060102150405")
-	if name == "" {
-		return fmt.Errorf("please specify a name for the new migration")
+// EnsureMigrationsTableContext is like EnsureMigrationsTable, but gives up as soon as
+// ctx is done
+func (db *DB) EnsureMigrationsTableContext(ctx context.Context) error {
+	if db.WaitBefore {
+		err := db.WaitContext(ctx)
+		if err != nil {
+			return err
+		}
 	}
-	name = fmt.Sprintf("%s_%s.sql", timestamp, name)
 
-	// create migrations dir if missing
-	if err := ensureDir(db.MigrationsDir); err != nil {
+	_, sqlDB, err := db.openDatabaseForMigration(ctx)
+	if err != nil {
 		return err
 	}
+	defer mustClose(sqlDB)
 
-	// check file does not already exist
-	path := filepath.Join(db.MigrationsDir, name)
-	fmt.Printf("Creating migration: %s\n", path)
+	return nil
+}
+
+// DumpSchema writes the current database schema to a file
+func (db *DB) DumpSchema() error {
+	return db.DumpSchemaContext(context.Background())
+}
 
-	if _, err := os.Stat(path); !os.IsNotExist(err) {
-		return fmt.Errorf("file already exists")
+// DumpSchemaContext is like DumpSchema, but gives up as soon as ctx is done. The
+// schema file is not modified once ctx is done.
+func (db *DB) DumpSchemaContext(ctx context.Context) error {
+	if db.DumpSchemaPerTable {
+		return db.dumpSchemaToTableFiles(ctx)
 	}
 
-	// write new migration
-	file, err := os.Create(path)
-	if err != nil {
+	// dump in memory first, so that the schema file is only replaced by a complete schema
+	var schema bytes.Buffer
+	if err := db.DumpSchemaToContext(ctx, &schema); err != nil {
 		return err
 	}
 
-	defer mustClose(file)
-	_, err = file.WriteString(migrationTemplate)
-	return err
-}
-
-func doTransaction(db *sql.DB, txFunc func(Transaction) error) error {
-	tx, err := db.Begin()
-	if err != nil {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
 
-	if err := txFunc(tx); err != nil {
-		if err1 := tx.Rollback(); err1 != nil {
-			return err1
-		}
+	db.logf("Writing: %s\n", db.SchemaFile)
+	db.emit(Event{Type: EventDumping, Path: db.SchemaFile})
 
+	// ensure schema directory exists
+	if err := ensureDir(db.fs(), filepath.Dir(db.SchemaFile), db.dirMode()); err != nil {
 		return err
 	}
 
-	return tx.Commit()
+	// write schema to file
+	_, compress := schemaFileFormat(db.SchemaFile)
+	return writeSchemaFile(db.fs(), db.SchemaFile, schema.Bytes(), compress, db.fileMode())
+}
+
+// DumpSchemaTo writes the schema of the database to w instead of the schema file, for
+// example to compare schemas in memory. SchemaFile does not need to be set: it only
+// selects the format (SQL, unless it has a .json extension). The schema is never
+// compressed, and is written as a whole even when DumpSchemaPerTable is enabled.
+func (db *DB) DumpSchemaTo(w io.Writer) error {
+	return db.DumpSchemaToContext(context.Background(), w)
 }
 
-func (db *DB) openDatabaseForMigration() (Driver, *sql.DB, error) {
-	drv, err := db.GetDriver()
-	if err != nil {
-		return nil, nil, err
+// DumpSchemaToContext is like DumpSchemaTo, but gives up as soon as ctx is done
+func (db *DB) DumpSchemaToContext(ctx context.Context, w io.Writer) error {
+	if db.WaitBefore {
+		err := db.WaitContext(ctx)
+		if err != nil {
+			return err
+		}
 	}
 
-	sqlDB, err := drv.Open(db.DatabaseURL)
+	drv, sqlDB, err := db.openDatabaseForMigration(ctx)
 	if err != nil {
-		return nil, nil, err
+		return err
 	}
+	defer mustClose(sqlDB)
 
-	if err := drv.CreateMigrationsTable(sqlDB); err != nil {
-		mustClose(sqlDB)
-		return nil, nil, err
+	u, err := db.connectionURL()
+	if err != nil {
+		return err
 	}
 
-	return drv, sqlDB, nil
-}
+	format, _ := schemaFileFormat(db.SchemaFile)
 
-func parseStatements(script string) []string {
-	var (
-		s          scanner.Scanner
-		statement  string
-		statements []string
-		parsedLast bool
-	)
-
-	s.Init(strings.NewReader(script))
-	s.Whitespace ^= 1<<' ' | 1<<'\t' | 1<<'\n'
-	s.IsIdentRune = func(ch rune, i int) bool {
-		return false
+	var schema []byte
+	if format == schemaFormatJSON {
+		schema, err = introspectSchemaJSON(ctx, drv, u, sqlDB)
+	} else {
+		schema, err = drv.DumpSchema(u, sqlDB)
 	}
-	s.Error = nil
-	s.Mode ^= scanner.ScanChars
-
-	for tok := s.Scan(); !parsedLast; tok = s.Scan() {
-		if tok == scanner.EOF {
-			parsedLast = true
-		}
-
-		switch tok {
-		case endOfStatement, scanner.EOF:
-			if strings.TrimSpace(statement) != "" {
-				statements = append(statements, statement)
-			}
-			//restart
-			statement = ""
-		default:
-			statement += s.TokenText()
-		}
+	if err != nil {
+		return err
 	}
 
-	return statements
-}
-
-func executeScript(tx Transaction, script string, nativeEngine bool) error {
-	var err error
+	if format == schemaFormatSQL {
+		// record the driver, see checkSchemaDriver
+		schema = append(schemaDriverHeader(db.DatabaseURL.Scheme), db.wrapSchema(schema)...)
+	}
 
-	if nativeEngine {
-		fmt.Println("Executing script on native engine")
-	} else {
-		fmt.Println("Executing script on DBMate engine")
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	if nativeEngine {
-		_, err = tx.Exec(script)
+	if schema, err = db.normalizeSchema(schema); err != nil {
 		return err
 	}
 
-	for _, statement := range parseStatements(script) {
-		_, err = tx.Exec(statement)
-		if err != nil {
-			return err
+	if db.SchemaValidate != nil {
+		if err := db.SchemaValidate(schema); err != nil {
+			return fmt.Errorf("schema validation failed: %s", err)
 		}
 	}
 
+	_, err = w.Write(schema)
 	return err
 }
 
-// Migrate migrates database to the latest version
-func (db *DB) Migrate() error {
-	files, err := findMigrationFiles(db.MigrationsDir, migrationFileRegexp)
-	if err != nil {
-		return err
-	}
-
-	if len(files) == 0 {
-		return fmt.Errorf("no migration files found")
-	}
-
+// dumpSchemaToTableFiles writes the schema of each table to a separate file,
+// see DumpSchemaPerTable
+func (db *DB) dumpSchemaToTableFiles(ctx context.Context) error {
 	if db.WaitBefore {
-		err := db.Wait()
+		err := db.WaitContext(ctx)
 		if err != nil {
 			return err
 		}
 	}
 
-	drv, sqlDB, err := db.openDatabaseForMigration()
+	drv, sqlDB, err := db.openDatabaseForMigration(ctx)
 	if err != nil {
 		return err
 	}
 	defer mustClose(sqlDB)
 
-	useNative := db.NativeEngine && db.DatabaseURL.Scheme != "oracle"
-
-	applied, err := drv.SelectMigrations(sqlDB, -1)
-	if err != nil {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
 
-	for _, filename := range files {
-		ver := migrationVersion(filename)
-		if ok := applied[ver]; ok {
-			// migration already applied
+	format, compress := schemaFileFormat(db.SchemaFile)
+	return db.dumpSchemaPerTable(drv, sqlDB, format, compress)
+}
+
+// LoadSchema executes the SQL schema file against the database, which is faster than
+// applying every migration to a new database. The versions recorded in the schema file
+// are then marked as applied, so that Migrate only applies newer migrations.
+func (db *DB) LoadSchema() error {
+	return db.LoadSchemaContext(context.Background())
+}
+
+// LoadSchemaContext is like LoadSchema, but gives up as soon as ctx is done
+func (db *DB) LoadSchemaContext(ctx context.Context) error {
+	format, compressed := schemaFileFormat(db.SchemaFile)
+	if format != schemaFormatSQL {
+		return fmt.Errorf("only SQL schema files can be loaded: %s", db.SchemaFile)
+	}
+	if db.DumpSchemaPerTable {
+		return fmt.Errorf("schema files dumped per table cannot be loaded")
+	}
+
+	schema, err := readSchemaFile(db.fs(), db.SchemaFile, compressed)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("schema file does not exist: %s", db.SchemaFile)
+	} else if err != nil {
+		return err
+	}
+
+	if err := db.checkSchemaDriver(); err != nil {
+		return err
+	}
+
+	if db.DryRun {
+		db.logf("Would load: %s\n", db.SchemaFile)
+		return nil
+	}
+
+	if db.WaitBefore {
+		err := db.WaitContext(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	drv, err := db.GetDriver()
+	if err != nil {
+		return err
+	}
+
+	sqlDB, err := db.openDatabase(drv)
+	if err != nil {
+		return err
+	}
+	defer mustClose(sqlDB)
+
+	db.logf("Loading: %s\n", db.SchemaFile)
+
+	// the schema file usually creates the migrations table itself, so it is only
+	// created afterwards if needed
+	err = doTransaction(ctx, sqlDB, nil, func(tx Transaction) error {
+		return db.executeScript(drv, db.traceTransaction(drv, tx), string(schema),
+			db.useNativeEngine(drv))
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := db.createMigrationsTable(drv, sqlDB); err != nil {
+		return err
+	}
+
+	recorded, _, err := readSchemaVersions(db.fs(), db.SchemaFile, compressed)
+	if err != nil {
+		return err
+	}
+
+	applied, err := db.selectMigrations(drv, sqlDB, -1)
+	if err != nil {
+		return err
+	}
+
+	versions := []string{}
+	for ver := range recorded {
+		if !applied[ver] {
+			versions = append(versions, ver)
+		}
+	}
+	sort.Strings(versions)
+
+	if len(versions) > 0 {
+		err = doTransaction(ctx, sqlDB, nil, func(tx Transaction) error {
+			return insertMigrations(drv, db.traceTransaction(drv, tx), versions)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return db.writeVersionFile(drv, sqlDB)
+}
+
+// checkSchemaDriver returns an error if the schema file was dumped from a database
+// using a different driver, which usually means that the database URL points to
+// the wrong database engine
+func (db *DB) checkSchemaDriver() error {
+	format, compressed := schemaFileFormat(db.SchemaFile)
+	if format != schemaFormatSQL {
+		return nil
+	}
+
+	path := db.migrationsSchemaFile(compressed)
+	scheme, err := readSchemaDriver(db.fs(), path, compressed)
+	if err != nil || scheme == "" {
+		return err
+	}
+
+	drv, err := db.GetDriver()
+	if err != nil {
+		return err
+	}
+
+	if recorded, err := GetDriver(scheme); err == nil && reflect.TypeOf(recorded) == reflect.TypeOf(drv) {
+		return nil
+	}
+
+	return fmt.Errorf("schema file `%s` was dumped from a %s database, but the database URL uses %s "+
+		"(remove or regenerate the schema file if the database engine has changed)",
+		path, scheme, db.DatabaseURL.Scheme)
+}
+
+// introspectSchemaJSON returns the structured schema encoded as JSON
+func introspectSchemaJSON(ctx context.Context, drv Driver, u *url.URL, sqlDB *sql.DB) ([]byte, error) {
+	introspector, ok := drv.(SchemaIntrospector)
+	if !ok {
+		return nil, fmt.Errorf("json schema format is not supported by driver: %s", u.Scheme)
+	}
+
+	schema, err := introspector.IntrospectSchema(ctx, u, sqlDB)
+	if err != nil {
+		return nil, err
+	}
+
+	return marshalSchema(schema)
+}
+
+const migrationTemplate = "-- migrate:up\n\n\n-- migrate:down\n\n"
+
+// newMigrationPath returns the path of a new migration file with the given name
+func (db *DB) newMigrationPath(name string) (string, error) {
+	// new migration name
+	timestamp := db.timeNow().UTC().Format("20060102150405")
+	if name == "" {
+		return "", fmt.Errorf("please specify a name for the new migration")
+	}
+	name = fmt.Sprintf("%s_%s.sql", timestamp, name)
+
+	return filepath.Join(db.migrationsDirs()[0], name), nil
+}
+
+// NewMigration creates a new migration file, and returns its absolute path
+func (db *DB) NewMigration(name string) (string, error) {
+	template := migrationTemplate
+	if db.MigrationTemplate != "" {
+		template = db.MigrationTemplate
+	}
+
+	return db.writeNewMigration(name, template)
+}
+
+// writeNewMigration creates a new migration file with the given contents, and
+// returns its absolute path
+func (db *DB) writeNewMigration(name, contents string) (string, error) {
+	path, err := db.newMigrationPath(name)
+	if err != nil {
+		return "", err
+	}
+
+	// create migrations dir if missing
+	if err := ensureDir(db.fs(), filepath.Dir(path), db.dirMode()); err != nil {
+		return "", err
+	}
+
+	// check file does not already exist
+	db.logf("Creating migration: %s\n", path)
+
+	if _, err := db.fs().Stat(path); !os.IsNotExist(err) {
+		return "", fmt.Errorf("file already exists")
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	// write new migration
+	if err := db.fs().WriteFile(path, []byte(contents), db.fileMode()); err != nil {
+		return "", err
+	}
+
+	return absPath, nil
+}
+
+// MigrationNameAvailable reports whether a migration with the given name could be
+// created right now without conflicting with an existing file, and returns the path
+// it would be written to. A conflict is either an existing file at that path, or an
+// existing migration sharing the same version. The version is derived from the current
+// time, so the result only holds until the clock moves to the next second.
+func (db *DB) MigrationNameAvailable(name string) (bool, string, error) {
+	path, err := db.newMigrationPath(name)
+	if err != nil {
+		return false, "", err
+	}
+
+	if _, err := db.fs().Stat(path); !os.IsNotExist(err) {
+		return false, path, nil
+	}
+
+	// nothing can conflict in migrations dirs which have not been created yet
+	dirs := []string{}
+	for _, dir := range db.migrationsDirs() {
+		if _, err := db.fs().Stat(dir); !os.IsNotExist(err) {
+			dirs = append(dirs, dir)
+		}
+	}
+	if len(dirs) == 0 {
+		return true, path, nil
+	}
+
+	ver := regexp.QuoteMeta(migrationVersion(filepath.Base(path)))
+	re := regexp.MustCompile(fmt.Sprintf(`^%s\D.*\.sql$`, ver))
+
+	files, err := findMigrationFilesIn(db.fs(), dirs, re, db.Recursive)
+	if err != nil {
+		return false, path, err
+	}
+
+	return len(files) == 0, path, nil
+}
+
+// doTransaction executes txFunc in a transaction, which is committed if txFunc succeeds
+// and rolled back otherwise. If the rollback fails too, the returned error wraps the
+// error of txFunc and mentions the rollback failure. Commit failures are prefixed with
+// "commit failed", since the statements of txFunc all succeeded.
+func doTransaction(ctx context.Context, db *sql.DB, opts *sql.TxOptions,
+	txFunc func(Transaction) error) error {
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	if err := txFunc(contextTransaction{ctx: ctx, tx: tx}); err != nil {
+		// the transaction is already rolled back if ctx was canceled
+		if err1 := tx.Rollback(); err1 != nil && err1 != sql.ErrTxDone {
+			return fmt.Errorf("%w (rollback also failed: %s)", err, err1)
+		}
+
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit failed: %w", err)
+	}
+
+	return nil
+}
+
+// doConn executes txFunc on a single dedicated connection outside of a transaction,
+// so that session state set by one statement is visible to the following statements
+func doConn(ctx context.Context, db *sql.DB, txFunc func(Transaction) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer mustClose(conn)
+
+	return txFunc(contextTransaction{ctx: ctx, tx: conn})
+}
+
+func (db *DB) openDatabaseForMigration(ctx context.Context) (Driver, *sql.DB, error) {
+	drv, sqlDB, err := db.connectDatabase(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := db.prepareMigrationsTable(drv, sqlDB); err != nil {
+		mustClose(sqlDB)
+		return nil, nil, err
+	}
+
+	return drv, sqlDB, nil
+}
+
+// connectDatabase opens the database and verifies the connection, giving up as soon
+// as ctx is done
+func (db *DB) connectDatabase(ctx context.Context) (Driver, *sql.DB, error) {
+	drv, err := db.GetDriver()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sqlDB, err := db.openDatabase(drv)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := sqlDB.PingContext(ctx); err != nil {
+		mustClose(sqlDB)
+		return nil, nil, err
+	}
+
+	return drv, sqlDB, nil
+}
+
+// prepareMigrationsTable creates the migrations table, the columns used by the enabled
+// options, and the audit table, if they do not already exist
+func (db *DB) prepareMigrationsTable(drv Driver, sqlDB *sql.DB) error {
+	if db.DryRun {
+		// dry run must not modify the database
+		return nil
+	}
+
+	if err := db.createMigrationsTable(drv, sqlDB); err != nil {
+		return err
+	}
+
+	if db.validateChecksums() {
+		store, err := db.checksumStore(drv)
+		if err == nil {
+			_, err = db.checksumAlgo()
+		}
+		if err == nil {
+			err = store.CreateChecksumColumn(sqlDB)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if db.RecordBatches {
+		store, err := db.batchStore(drv)
+		if err == nil {
+			err = store.CreateBatchColumn(sqlDB)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if db.RecordTimings {
+		store, err := db.timingStore(drv)
+		if err == nil {
+			err = store.CreateTimingColumns(sqlDB)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if db.AuditTableName != "" {
+		store, err := db.auditStore(drv)
+		if err == nil {
+			err = store.CreateAuditTable(sqlDB, db.AuditTableName)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// selectMigrations returns applied migrations with an optional limit. During a dry run
+// the migrations table may not exist yet, in which case no migrations are applied.
+func (db *DB) selectMigrations(drv Driver, sqlDB *sql.DB, limit int) (map[string]bool, error) {
+	applied, err := selectAppliedMigrations(drv, sqlDB, limit)
+	if err != nil && db.DryRun {
+		db.warnf("Unable to read applied migrations, assuming none: %s\n", err)
+		return map[string]bool{}, nil
+	}
+
+	return applied, err
+}
+
+// printDryRun prints a migration script which would be executed during a dry run
+func (db *DB) printDryRun(action, filename string, migration Migration, transaction bool) {
+	db.logf("Would %s: %s (transaction: %t)\n", action, filename, transaction)
+	db.logf("%s\n\n", strings.TrimSpace(migration.Contents))
+}
+
+// useTransaction returns whether a migration block should run inside a transaction.
+// Blocks with `transaction:auto` run inside a transaction only if the driver supports
+// transactional DDL.
+// With AutoDetectConcurrent, blocks containing a statement which the driver reports
+// cannot run inside a transaction run outside of one, and a warning is logged.
+func (db *DB) useTransaction(drv Driver, filename string, migration Migration) bool {
+	if !migration.Options.Transaction() {
+		return false
+	}
+
+	if migration.Options.TransactionAuto() && !drv.Capabilities().TransactionalDDL {
+		return false
+	}
+
+	detector, ok := drv.(NonTransactionalDetector)
+	if !db.AutoDetectConcurrent || !ok {
+		return true
+	}
+
+	statement := detector.NonTransactionalStatement(migration.Contents)
+	if statement == "" {
+		return true
+	}
+
+	db.warnf("Warning: %s: running outside of a transaction, because `%s` cannot run "+
+		"inside a transaction\n", filename, strings.Join(strings.Fields(statement), " "))
+	return false
+}
+
+func (db *DB) createMigrationsTable(drv Driver, sqlDB *sql.DB) error {
+	if db.CreateMigrationsTableFunc != nil {
+		return db.CreateMigrationsTableFunc(sqlDB)
+	}
+
+	return drv.CreateMigrationsTable(sqlDB)
+}
+
+// executeMigration runs a migration block, calling the function of Go migrations
+// and executing the script of migration files
+func (db *DB) executeMigration(drv Driver, tx Transaction, migration Migration, nativeEngine bool) error {
+	if migration.run != nil {
+		gtx, ok := tx.(GoMigrationTx)
+		if !ok {
+			return fmt.Errorf("this transaction cannot run Go migrations")
+		}
+
+		ctx := context.Background()
+		if switcher, ok := tx.(contextSwitcher); ok {
+			ctx = switcher.txContext()
+		}
+
+		return migration.run(ctx, gtx)
+	}
+
+	return db.executeScript(drv, tx, migration.Contents, nativeEngine)
+}
+
+// executeMigrationWithHooks executes a migration between the before and after scripts,
+// which are skipped when empty
+func (db *DB) executeMigrationWithHooks(drv Driver, tx Transaction, migration Migration,
+	nativeEngine bool, before, after string) error {
+	if before != "" {
+		if err := db.executeScript(drv, tx, before, nativeEngine); err != nil {
+			return fmt.Errorf("before hook failed: %s", err)
+		}
+	}
+
+	if err := db.executeMigration(drv, tx, migration, nativeEngine); err != nil {
+		return err
+	}
+
+	if after != "" {
+		if err := db.executeScript(drv, tx, after, nativeEngine); err != nil {
+			return fmt.Errorf("after hook failed: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// useNativeEngine returns whether migration scripts should be executed by the native
+// engine. Drivers which cannot execute several statements at once always use the
+// DBMate engine, even if the native engine is enabled.
+func (db *DB) useNativeEngine(drv Driver) bool {
+	return db.NativeEngine && drv.Capabilities().MultiStatementExec
+}
+
+// migrationNativeEngine returns whether the script of a migration should be executed by
+// the native engine. The engine option of the migration takes precedence over useNative,
+// the engine chosen for the driver by useNativeEngine.
+func migrationNativeEngine(drv Driver, migration Migration, useNative bool) (bool, error) {
+	switch engine := migration.Options.Engine(); engine {
+	case "":
+		return useNative, nil
+	case "dbmate":
+		return false, nil
+	case "native":
+		if !drv.Capabilities().MultiStatementExec {
+			return false, fmt.Errorf("the native engine is not supported by this driver")
+		}
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid engine `%s`", engine)
+	}
+}
+
+// executeScript runs a migration script. The native engine passes the whole script
+// to the driver (see ScriptExecutor), while the DBMate engine splits it into
+// statements (see StatementSplitter) and executes them one at a time.
+func (db *DB) executeScript(drv Driver, tx Transaction, script string, nativeEngine bool) error {
+	var err error
+
+	if db.StatementTimeout > 0 {
+		tx = timeoutTransaction{Transaction: tx, timeout: db.StatementTimeout}
+	}
+
+	if db.Verbose {
+		if nativeEngine {
+			db.debugf("Executing script on native engine\n")
+		} else {
+			db.debugf("Executing script on DBMate engine\n")
+		}
+	}
+
+	if nativeEngine {
+		if executor, ok := drv.(ScriptExecutor); ok {
+			return executor.ExecScript(tx, script)
+		}
+
+		_, err = tx.Exec(script)
+		return err
+	}
+
+	var statements []string
+	if splitter, ok := drv.(StatementSplitter); ok {
+		statements = splitter.SplitStatements(script)
+	} else {
+		statements = parseStatements(script)
+	}
+	for i, statement := range statements {
+		if db.Verbose {
+			db.debugf("Executing statement %d of %d\n", i+1, len(statements))
+		}
+
+		_, err = tx.Exec(statement)
+		if err != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+// Migrate migrates database to the latest version
+func (db *DB) Migrate() error {
+	return db.MigrateContext(context.Background())
+}
+
+// MigrateContext is like Migrate, but stops as soon as ctx is done. Statements are
+// executed with ctx, so a canceled migration is rolled back (if it runs in a
+// transaction), and no further migrations are applied.
+func (db *DB) MigrateContext(ctx context.Context) error {
+	_, err := db.migrate(ctx, nil, db.AutoDumpSchema)
+	return err
+}
+
+// MigrateApplied is like Migrate, but also returns the versions of the migrations it
+// applied, in order. The slice is empty if the database was already up to date (or
+// during a dry run). When an error is returned, it lists the migrations applied before
+// the error occurred.
+func (db *DB) MigrateApplied() ([]string, error) {
+	return db.MigrateAppliedContext(context.Background())
+}
+
+// MigrateAppliedContext is like MigrateApplied, but stops as soon as ctx is done
+func (db *DB) MigrateAppliedContext(ctx context.Context) ([]string, error) {
+	return db.migrate(ctx, nil, db.AutoDumpSchema)
+}
+
+// MigrateWithoutDump is like Migrate, but never updates the schema file, regardless
+// of AutoDumpSchema. It lets the caller choose when to dump the schema, e.g. once
+// after migrating many databases.
+func (db *DB) MigrateWithoutDump() error {
+	return db.MigrateWithoutDumpContext(context.Background())
+}
+
+// MigrateWithoutDumpContext is like MigrateWithoutDump, but stops as soon as ctx is done
+func (db *DB) MigrateWithoutDumpContext(ctx context.Context) error {
+	_, err := db.migrate(ctx, nil, false)
+	return err
+}
+
+// MigrateTo migrates database up or down to the specified version. Applied migrations
+// with a newer version are rolled back first, newest first, then pending migrations are
+// applied in order, stopping after the migration with the specified version (or the
+// closest version not exceeding it). It does nothing if the database is already at
+// that version.
+func (db *DB) MigrateTo(version string) error {
+	return db.MigrateToContext(context.Background(), version)
+}
+
+// MigrateToContext is like MigrateTo, but stops as soon as ctx is done
+func (db *DB) MigrateToContext(ctx context.Context, version string) error {
+	if version == "" {
+		return fmt.Errorf("please specify a target version")
+	}
+
+	// check the target before rolling anything back
+	files, err := db.findMigrations()
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no migration files found")
+	}
+	if _, err := migrationFilesUpTo(files, version); err != nil {
+		return err
+	}
+
+	// a single lock is held while rolling back and migrating, so that no other run
+	// gets in between
+	return db.withAdvisoryLock(ctx, func(locked *DB) error {
+		_, err := locked.rollbackVersions(ctx, false, func(drv Driver, sqlDB *sql.DB) ([]string, error) {
+			return locked.migrationsAfter(drv, sqlDB, version)
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = locked.migrate(ctx, func(files []string) ([]string, error) {
+			return migrationFilesUpTo(files, version)
+		}, locked.AutoDumpSchema)
+		return err
+	})
+}
+
+// migrate applies the pending migrations among the files returned by selectFiles, or
+// all pending migrations if selectFiles is nil, and returns the applied versions. When
+// selectFiles is set, nothing is applied if the last selected migration is already
+// applied. The schema file is updated afterwards if dump is true.
+func (db *DB) migrate(ctx context.Context, selectFiles func([]string) ([]string, error),
+	dump bool) (versions []string, err error) {
+	summary := MigrateSummary{Migrations: []MigrationTiming{}}
+	if db.RecordBatches {
+		summary.BatchID = db.BatchID
+		if summary.BatchID == "" {
+			summary.BatchID = newBatchID()
+		}
+	}
+	if db.AfterMigrateComplete != nil && !db.DryRun {
+		start := time.Now()
+		defer func() {
+			summary.Duration = time.Since(start)
+			summary.Err = err
+			db.AfterMigrateComplete(summary)
+		}()
+	}
+
+	files, err := db.findMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no migration files found")
+	}
+
+	if selectFiles != nil {
+		files, err = selectFiles(files)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := db.checkSchemaDriver(); err != nil {
+		return nil, err
+	}
+
+	// check the configuration before modifying the database
+	drv, err := db.GetDriver()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := drv.(ReplicaLagChecker); db.MaxReplicaLag > 0 && !ok {
+		return nil, fmt.Errorf("replica lag checks are not supported by driver: %s", db.DatabaseURL.Scheme)
+	}
+
+	if _, ok := drv.(ForeignKeyChecker); db.CheckForeignKeys && !ok {
+		return nil, fmt.Errorf("foreign key checks are not supported by driver: %s", db.DatabaseURL.Scheme)
+	}
+
+	if db.WaitBefore {
+		err := db.WaitContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	drv, sqlDB, unlock, err := db.openLockedDatabaseForMigration(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer mustClose(sqlDB)
+	defer unlock()
+
+	if err := db.checkMigrationsUnlocked(drv, sqlDB); err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		// keep the version file in sync with the migrations applied before a failure
+		if err != nil && len(summary.Migrations) == 0 {
+			return
+		}
+		if versionErr := db.writeVersionFile(drv, sqlDB); versionErr != nil && err == nil {
+			err = versionErr
+		}
+	}()
+
+	useNative := db.useNativeEngine(drv)
+
+	applied, err := db.selectMigrations(drv, sqlDB, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	if db.validateChecksums() {
+		modified, err := db.modifiedMigrations(drv, sqlDB, files)
+		if err != nil {
+			return nil, err
+		}
+		changed := []string{}
+		for _, filename := range files {
+			if !modified[migrationVersion(filename)] {
+				continue
+			}
+			if db.FailOnChecksumMismatch {
+				changed = append(changed, filename)
+			} else {
+				db.warnf("Warning: %s has been modified since it was applied\n", filename)
+			}
+		}
+		if len(changed) > 0 {
+			return nil, modifiedMigrationsError(changed)
+		}
+	}
+
+	if db.StrictOrdering {
+		if err := checkStrictOrdering(files, applied); err != nil {
+			return nil, err
+		}
+	}
+
+	if selectFiles != nil && applied[migrationVersion(files[len(files)-1])] {
+		// target migration already applied
+		return []string{}, nil
+	}
+
+	if db.BeforeMigrateSQL != "" && !db.DryRun {
+		if _, err := sqlDB.ExecContext(ctx, db.BeforeMigrateSQL); err != nil {
+			return nil, fmt.Errorf("before migrate statement failed: %s", err)
+		}
+	}
+
+	if db.AfterMigrateSQL != "" && !db.DryRun {
+		defer func() {
+			// not bound to ctx, so that it also runs when migrating was canceled
+			_, afterErr := sqlDB.Exec(db.AfterMigrateSQL)
+			if afterErr == nil {
+				return
+			}
+
+			if err == nil {
+				err = fmt.Errorf("after migrate statement failed, migrations were applied: %s", afterErr)
+			} else {
+				db.errorf("After migrate statement failed: %s\n", afterErr)
+			}
+		}()
+	}
+
+	failed := []string{}
+	for _, filename := range files {
+		ver := migrationVersion(filename)
+		if ok := applied[ver]; ok {
+			// migration already applied
+			continue
+		}
+
+		up, down, err := db.loadMigration(filename)
+		if err != nil {
+			return summary.versions(), err
+		}
+
+		if db.RequireDown && !isGoMigration(filename) && !down.Options.Irreversible() &&
+			!hasStatements(down.Contents) {
+			return summary.versions(), fmt.Errorf("%s: down migration is empty (add statements "+
+				"to the down block, or declare it with `-- migrate:down irreversible:true`)", filename)
+		}
+
+		txOpts, err := migrationTxOptions(up, db.DefaultTxOptions)
+		if err != nil {
+			return summary.versions(), fmt.Errorf("%s: %s", filename, err)
+		}
+
+		nativeEngine, err := migrationNativeEngine(drv, up, useNative)
+		if err != nil {
+			return summary.versions(), fmt.Errorf("%s: %s", filename, err)
+		}
+		transaction := db.useTransaction(drv, filename, up)
+
+		if db.Strict && !isGoMigration(filename) {
+			fsys, _, name := db.migrationPath(filename)
+			issue, err := checkMigrationEncoding(fsys, name, filename)
+			if err != nil {
+				return summary.versions(), err
+			}
+			if issue != nil {
+				return summary.versions(), fmt.Errorf("%s", issue)
+			}
+
+			warnings, err := db.checkMigrationFile(fsys, name, filename)
+			if err != nil {
+				return summary.versions(), err
+			}
+			for _, w := range warnings {
+				db.warnf("Warning: %s\n", w)
+			}
+		}
+
+		if db.DryRun {
+			db.printDryRun("apply", filename, up, transaction)
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return summary.versions(), err
+		}
+
+		if db.DelayBetweenMigrations > 0 && len(summary.Migrations) > 0 {
+			// give replicas time to catch up with the previous migration
+			db.logf("Pausing for %s\n", db.DelayBetweenMigrations)
+			select {
+			case <-ctx.Done():
+				return summary.versions(), ctx.Err()
+			case <-time.After(db.DelayBetweenMigrations):
+			}
+		}
+
+		if db.MaxReplicaLag > 0 && len(summary.Migrations) > 0 {
+			if err := db.waitReplicaLag(ctx, drv.(ReplicaLagChecker), sqlDB); err != nil {
+				return summary.versions(), err
+			}
+		}
+
+		if checker, ok := drv.(DiskSpaceChecker); ok && db.MinFreeBytes > 0 {
+			if err := checker.CheckFreeSpace(db.DatabaseURL, db.MinFreeBytes); err != nil {
+				return summary.versions(), err
+			}
+		}
+
+		db.logf("Applying: %s\n", filename)
+		db.emit(Event{Type: EventApplying, Version: ver, Filename: filename})
+		start := time.Now()
+
+		execMigration := func(tx Transaction) error {
+			tx = db.traceTransaction(drv, tx)
+
+			// run actual migration
+			err := withSession(tx, up.Options.Session(), func() error {
+				return db.executeMigrationWithHooks(drv, tx, up, nativeEngine,
+					db.BeforeEachMigration, db.AfterEachMigration)
+			})
+			if err != nil {
+				return err
+			}
+
+			// record migration
+			if err := drv.InsertMigration(tx, ver); err != nil {
+				return err
+			}
+
+			if db.validateChecksums() && !isGoMigration(filename) {
+				// checked by openDatabaseForMigration
+				store := drv.(ChecksumStore)
+				algo, _ := db.checksumAlgo()
+				if err := store.UpdateMigrationChecksum(tx, ver, migrationChecksum(algo, migrationContents(up, down))); err != nil {
+					return err
+				}
+			}
+
+			if db.RecordBatches {
+				// checked by openDatabaseForMigration
+				if err := drv.(BatchStore).UpdateMigrationBatch(tx, ver, summary.BatchID); err != nil {
+					return err
+				}
+			}
+
+			if db.RecordTimings {
+				// checked by openDatabaseForMigration
+				err := drv.(TimingStore).UpdateMigrationTiming(tx, ver, time.Now().UTC(), time.Since(start))
+				if err != nil {
+					return err
+				}
+			}
+
+			return db.auditMigration(drv, tx, ver, "up", true)
+		}
+
+		if transaction {
+			// begin transaction
+			err = doTransaction(ctx, sqlDB, txOpts, execMigration)
+		} else if len(up.Options.Session()) > 0 {
+			// session statements must run on the same connection as the migration
+			err = doConn(ctx, sqlDB, execMigration)
+		} else {
+			// run outside of transaction
+			err = execMigration(contextTransaction{ctx: ctx, tx: sqlDB})
+		}
+
+		if err != nil {
+			db.auditFailure(drv, sqlDB, ver, "up")
+			db.emit(Event{Type: EventFailed, Version: ver, Filename: filename,
+				Duration: time.Since(start), Err: err})
+
+			if !db.ContinueOnError || ctx.Err() != nil {
+				return summary.versions(), err
+			}
+
+			db.errorf("Failed: %s: %s\n", filename, err)
+			failed = append(failed, fmt.Sprintf("%s (%s)", ver, err))
+			continue
+		}
+
+		timing := MigrationTiming{
+			Version:  ver,
+			Filename: filename,
+			Duration: time.Since(start),
+		}
+		summary.Migrations = append(summary.Migrations, timing)
+		db.emit(Event{Type: EventApplied, Version: ver, Filename: filename, Duration: timing.Duration})
+	}
+
+	if db.RecordBatches && len(summary.Migrations) > 0 {
+		db.logf("Recorded batch: %s\n", summary.BatchID)
+	}
+
+	// automatically update schema file, silence errors
+	if dump && !db.DryRun {
+		_ = db.DumpSchemaContext(ctx)
+	}
+
+	if db.CheckForeignKeys && len(summary.Migrations) > 0 {
+		violations, err := drv.(ForeignKeyChecker).CheckForeignKeys(sqlDB)
+		if err != nil {
+			return summary.versions(), fmt.Errorf("unable to check foreign keys: %s", err)
+		}
+		if len(violations) > 0 {
+			return summary.versions(), fmt.Errorf("foreign key check found %d violations after migrating: %s",
+				len(violations), strings.Join(violations, "; "))
+		}
+	}
+
+	if len(failed) > 0 {
+		return summary.versions(), fmt.Errorf("%d migrations failed: %s", len(failed),
+			strings.Join(failed, "; "))
+	}
+
+	return summary.versions(), nil
+}
+
+// waitReplicaLag blocks until the replication lag drops below MaxReplicaLag, and
+// fails if it is still too high after ReplicaLagTimeout
+func (db *DB) waitReplicaLag(ctx context.Context, checker ReplicaLagChecker, sqlDB *sql.DB) error {
+	interval := db.ReplicaLagInterval
+	if interval <= 0 {
+		interval = DefaultReplicaLagInterval
+	}
+	deadline := time.Now().Add(db.ReplicaLagTimeout)
+
+	for {
+		lag, err := checker.ReplicaLag(sqlDB)
+		if err != nil {
+			return fmt.Errorf("unable to check replica lag: %s", err)
+		}
+		if lag <= db.MaxReplicaLag {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("replica lag is still %s after waiting %s (maximum is %s)",
+				lag, db.ReplicaLagTimeout, db.MaxReplicaLag)
+		}
+
+		db.logf("Waiting for replica lag of %s to drop below %s\n", lag, db.MaxReplicaLag)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Baseline records the migrations up to and including version as applied, without
+// executing them. This allows dbmate to be adopted by an existing database which
+// already contains the schema created by these migrations. An empty version
+// baselines every migration. Migrations which are already applied are skipped.
+func (db *DB) Baseline(version string) error {
+	return db.BaselineContext(context.Background(), version)
+}
+
+// BaselineContext is like Baseline, but gives up as soon as ctx is done
+func (db *DB) BaselineContext(ctx context.Context, version string) error {
+	files, err := db.findMigrations()
+	if err != nil {
+		return err
+	}
+
+	if len(files) == 0 {
+		return fmt.Errorf("no migration files found")
+	}
+
+	if version != "" {
+		files, err = migrationFilesUpTo(files, version)
+		if err != nil {
+			return err
+		}
+	}
+
+	if db.WaitBefore {
+		err := db.WaitContext(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	drv, sqlDB, err := db.openDatabaseForMigration(ctx)
+	if err != nil {
+		return err
+	}
+	defer mustClose(sqlDB)
+
+	applied, err := db.selectMigrations(drv, sqlDB, -1)
+	if err != nil {
+		return err
+	}
+
+	versions := []string{}
+	for _, filename := range files {
+		ver := migrationVersion(filename)
+		if applied[ver] {
 			continue
 		}
 
-		fmt.Printf("Applying: %s\n", filename)
+		if db.DryRun {
+			db.logf("Would baseline: %s\n", filename)
+		} else {
+			db.logf("Baselining: %s\n", filename)
+		}
+		versions = append(versions, ver)
+	}
+
+	if db.DryRun || len(versions) == 0 {
+		return nil
+	}
+
+	err = doTransaction(ctx, sqlDB, nil, func(tx Transaction) error {
+		return insertMigrations(drv, db.traceTransaction(drv, tx), versions)
+	})
+	if err != nil {
+		return err
+	}
+
+	return db.writeVersionFile(drv, sqlDB)
+}
+
+// findMigrations returns the sorted names of migration files, merged with
+// registered Go migrations
+func (db *DB) findMigrations() ([]string, error) {
+	files, err := db.migrationFiles(db.migrationFilePattern())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkUniqueVersions(files); err != nil {
+		return nil, err
+	}
+
+	return db.mergeGoMigrations(files)
+}
+
+// MigrationFile is a parsed migration file, as returned by FindMigrations
+type MigrationFile struct {
+	Version  string
+	Filename string
+	// Up and Down hold the contents and options of each block. Down has empty
+	// Contents if the file does not define a down block.
+	Up   Migration
+	Down Migration
+}
+
+// FindMigrations parses every migration file in the migrations directory, ordered
+// by version, without connecting to the database. Go migrations are not included.
+func (db *DB) FindMigrations() ([]MigrationFile, error) {
+	files, err := db.migrationFiles(db.migrationFilePattern())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkUniqueVersions(files); err != nil {
+		return nil, err
+	}
+
+	migrations := make([]MigrationFile, 0, len(files))
+	for _, filename := range files {
+		up, down, err := db.loadMigration(filename)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse `%s`: %s", filename, err)
+		}
+
+		migrations = append(migrations, MigrationFile{
+			Version:  migrationVersion(filename),
+			Filename: filename,
+			Up:       up,
+			Down:     down,
+		})
+	}
+
+	return migrations, nil
+}
+
+// loadMigration returns the up and down blocks of a migration file or Go migration
+func (db *DB) loadMigration(name string) (Migration, Migration, error) {
+	if isGoMigration(name) {
+		up, down := db.parseGoMigration(migrationVersion(name))
+		return up, down, nil
+	}
+
+	return parseMigration(db.migrationPath(name))
+}
+
+// findMigrationFiles returns the sorted names of the files in dir matching re. Symlinks
+// are followed, both for dir itself and for the entries within it: a symlinked file is
+// listed (and ordered) under the name of the link, a link to a directory is skipped like
+// a directory, and a broken link is reported rather than silently skipped. When recursive
+// is set, files in subdirectories are included too, named by their path relative to dir,
+// and re is matched against their base name.
+func findMigrationFiles(fsys fs.FS, dir string, re *regexp.Regexp, recursive bool) ([]string, error) {
+	if recursive {
+		return findMigrationFilesRecursive(fsys, dir, re)
+	}
+
+	files, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not find migrations directory `%s`", dir)
+	}
+
+	matches := []string{}
+	for _, file := range files {
+		ok, err := isMigrationFile(fsys, path.Join(dir, file.Name()), file, re)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matches = append(matches, file.Name())
+		}
+	}
+
+	sortMigrationFiles(matches)
+
+	return matches, nil
+}
+
+// findMigrationFilesIn returns the sorted names of the files matching re in each of
+// dirs, see findMigrationFiles
+func findMigrationFilesIn(fsys fs.FS, dirs []string, re *regexp.Regexp, recursive bool) ([]string, error) {
+	if len(dirs) == 1 {
+		return findMigrationFiles(fsys, dirs[0], re, recursive)
+	}
+
+	files := []string{}
+	for _, dir := range dirs {
+		matches, err := findMigrationFiles(fsys, dir, re, recursive)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+
+	sortMigrationFiles(files)
+
+	return files, nil
+}
+
+// findMigrationFilesRecursive returns the sorted paths, relative to dir, of the files
+// in dir and its subdirectories whose base name matches re. Symlinks to directories
+// are not followed.
+func findMigrationFilesRecursive(fsys fs.FS, dir string, re *regexp.Regexp) ([]string, error) {
+	if _, err := fs.ReadDir(fsys, dir); err != nil {
+		return nil, fmt.Errorf("could not find migrations directory `%s`", dir)
+	}
+
+	// the paths of entries are cleaned, e.g. `db/migrations/a.sql` for `./db/migrations`
+	prefix := path.Clean(dir) + "/"
+	if prefix == "./" {
+		prefix = ""
+	}
+
+	matches := []string{}
+	err := fs.WalkDir(fsys, dir, func(name string, file fs.DirEntry, err error) error {
+		if err != nil || file.IsDir() {
+			return err
+		}
+
+		ok, err := isMigrationFile(fsys, name, file, re)
+		if ok {
+			matches = append(matches, strings.TrimPrefix(name, prefix))
+		}
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortMigrationFiles(matches)
+
+	return matches, nil
+}
+
+// isMigrationFile returns whether the directory entry at name is a file (or a link to
+// a file) whose base name matches re
+func isMigrationFile(fsys fs.FS, name string, file fs.DirEntry, re *regexp.Regexp) (bool, error) {
+	if file.IsDir() || !re.MatchString(file.Name()) {
+		return false, nil
+	}
+
+	if file.Type()&fs.ModeSymlink != 0 {
+		info, err := fs.Stat(fsys, name)
+		if err != nil {
+			return false, fmt.Errorf("unable to follow symlink `%s`: %s", name, err)
+		}
+		if info.IsDir() {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// sortMigrationFiles sorts migration files by base name, so that files in
+// subdirectories are ordered by version across the whole tree
+func sortMigrationFiles(files []string) {
+	sort.SliceStable(files, func(i, j int) bool {
+		bi, bj := path.Base(files[i]), path.Base(files[j])
+		if bi != bj {
+			return bi < bj
+		}
+		return files[i] < files[j]
+	})
+}
+
+// migrationFilesUpTo returns the sorted migration files with a version lower than
+// or equal to target, so that the last file is the closest match for target
+func migrationFilesUpTo(files []string, target string) ([]string, error) {
+	matches := []string{}
+	for _, filename := range files {
+		if migrationVersion(filename) <= target {
+			matches = append(matches, filename)
+		}
+	}
+
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("can't find migration file for version: %s", target)
+	}
+
+	return matches, nil
+}
+
+// findMigrationFile returns the migration file with the given version, and an error if
+// there is none, or if several files share the version
+func (db *DB) findMigrationFile(ver string) (string, error) {
+	if ver == "" {
+		panic("migration version is required")
+	}
+
+	files, err := db.migrationFiles(db.migrationFilePattern())
+	if err != nil {
+		return "", err
+	}
+
+	matches := []string{}
+	for _, filename := range files {
+		if migrationVersion(filename) == ver {
+			matches = append(matches, filename)
+		}
+	}
+
+	if len(matches) == 0 {
+		return "", fmt.Errorf("can't find migration file: %s*.sql", ver)
+	}
+
+	if err := checkUniqueVersions(matches); err != nil {
+		return "", err
+	}
+
+	return matches[0], nil
+}
+
+// checkUniqueVersions returns an error naming the first two migration files which
+// share the same version. Otherwise only one of them would ever be applied, since
+// migrations are recorded by version.
+func checkUniqueVersions(files []string) error {
+	seen := map[string]string{}
+	for _, filename := range files {
+		ver := migrationVersion(filename)
+		if first, ok := seen[ver]; ok {
+			return fmt.Errorf("duplicate migration version %s: `%s` and `%s`", ver, first, filename)
+		}
+
+		seen[ver] = filename
+	}
+
+	return nil
+}
+
+func migrationVersion(filename string) string {
+	return regexp.MustCompile(`^\d+`).FindString(path.Base(filename))
+}
 
-		up, _, err := parseMigration(filepath.Join(db.MigrationsDir, filename))
-		if err != nil {
-			return err
-		}
+// Rollback rolls back the most recent migration
+func (db *DB) Rollback() error {
+	return db.RollbackContext(context.Background())
+}
 
-		execMigration := func(tx Transaction) error {
-			// run actual migration
-			if err := executeScript(tx, up.Contents, useNative); err != nil {
-				return err
-			}
+// RollbackContext is like Rollback, but stops as soon as ctx is done
+func (db *DB) RollbackContext(ctx context.Context) error {
+	_, err := db.rollback(ctx, 1, db.AutoDumpSchema)
+	return err
+}
 
-			// record migration
-			return drv.InsertMigration(tx, ver)
-		}
+// RollbackWithoutDump is like Rollback, but never updates the schema file,
+// regardless of AutoDumpSchema
+func (db *DB) RollbackWithoutDump() error {
+	return db.RollbackWithoutDumpContext(context.Background())
+}
 
-		if up.Options.Transaction() {
-			// begin transaction
-			err = doTransaction(sqlDB, execMigration)
-		} else {
-			// run outside of transaction
-			err = execMigration(sqlDB)
-		}
+// RollbackWithoutDumpContext is like RollbackWithoutDump, but stops as soon as ctx is done
+func (db *DB) RollbackWithoutDumpContext(ctx context.Context) error {
+	_, err := db.rollback(ctx, 1, false)
+	return err
+}
 
-		if err != nil {
-			return err
-		}
+// RollbackN rolls back the n most recent migrations, newest first. If fewer than n
+// migrations have been applied, all of them are rolled back. Each migration is rolled
+// back separately (honoring its own transaction option), so if one of them fails,
+// the migrations rolled back before it remain rolled back.
+func (db *DB) RollbackN(n int) error {
+	return db.RollbackNContext(context.Background(), n)
+}
+
+// RollbackNContext is like RollbackN, but stops as soon as ctx is done
+func (db *DB) RollbackNContext(ctx context.Context, n int) error {
+	if n < 1 {
+		return fmt.Errorf("please specify a positive number of migrations to roll back")
 	}
 
-	// automatically update schema file, silence errors
-	if db.AutoDumpSchema {
-		_ = db.DumpSchema()
+	count, err := db.rollback(ctx, n, db.AutoDumpSchema)
+	if err != nil {
+		return err
+	}
+
+	if count < n {
+		db.logf("Rolled back %d of %d requested migrations\n", count, n)
 	}
 
 	return nil
 }
 
-func findMigrationFiles(dir string, re *regexp.Regexp) ([]string, error) {
-	files, err := ioutil.ReadDir(dir)
+// rollback rolls back up to n of the most recent migrations,
+// and returns how many were rolled back
+func (db *DB) rollback(ctx context.Context, n int, dump bool) (int, error) {
+	return db.rollbackVersions(ctx, dump, func(drv Driver, sqlDB *sql.DB) ([]string, error) {
+		return db.latestMigrations(drv, sqlDB, n)
+	})
+}
+
+// latestMigrations returns up to n of the most recent applied migrations, newest first
+func (db *DB) latestMigrations(drv Driver, sqlDB *sql.DB, n int) ([]string, error) {
+	applied, err := db.selectMigrations(drv, sqlDB, n)
 	if err != nil {
-		return nil, fmt.Errorf("could not find migrations directory `%s`", dir)
+		return nil, err
 	}
 
-	matches := []string{}
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
-
-		name := file.Name()
-		if !re.MatchString(name) {
-			continue
-		}
-
-		matches = append(matches, name)
+	versions := make([]string, 0, len(applied))
+	for ver := range applied {
+		versions = append(versions, ver)
 	}
+	sort.Sort(sort.Reverse(sort.StringSlice(versions)))
 
-	sort.Strings(matches)
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("can't rollback: no migrations have been applied")
+	}
 
-	return matches, nil
+	return versions, nil
 }
 
-func findMigrationFile(dir string, ver string) (string, error) {
-	if ver == "" {
-		panic("migration version is required")
+// migrationsAfter returns the applied migrations with a version newer than target,
+// newest first
+func (db *DB) migrationsAfter(drv Driver, sqlDB *sql.DB, target string) ([]string, error) {
+	applied, err := db.selectMigrations(drv, sqlDB, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []string{}
+	for ver := range applied {
+		if ver > target {
+			versions = append(versions, ver)
+		}
 	}
+	sort.Sort(sort.Reverse(sort.StringSlice(versions)))
 
-	ver = regexp.QuoteMeta(ver)
-	re := regexp.MustCompile(fmt.Sprintf(`^%s.*\.sql$`, ver))
+	return versions, nil
+}
+
+// Redo rolls back the most recent migration and applies it again, e.g. to rerun a
+// migration while developing it. Each direction honors the transaction option of its
+// own block, and the schema file is updated once, after the migration is reapplied.
+func (db *DB) Redo() error {
+	return db.RedoContext(context.Background())
+}
 
-	files, err := findMigrationFiles(dir, re)
+// RedoContext is like Redo, but stops as soon as ctx is done
+func (db *DB) RedoContext(ctx context.Context) error {
+	var version string
+	_, err := db.rollbackVersions(ctx, false, func(drv Driver, sqlDB *sql.DB) ([]string, error) {
+		versions, err := db.latestMigrations(drv, sqlDB, 1)
+		if err == nil {
+			version = versions[0]
+		}
+		return versions, err
+	})
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	if len(files) == 0 {
-		return "", fmt.Errorf("can't find migration file: %s*.sql", ver)
+	if db.DryRun {
+		// the migration is still applied, so there is nothing to reapply
+		return nil
 	}
 
-	return files[0], nil
+	_, err = db.migrate(ctx, func(files []string) ([]string, error) {
+		for _, filename := range files {
+			if migrationVersion(filename) == version {
+				return []string{filename}, nil
+			}
+		}
+		return nil, fmt.Errorf("can't find migration file for version: %s", version)
+	}, db.AutoDumpSchema)
+	return err
 }
 
-func migrationVersion(filename string) string {
-	return regexp.MustCompile(`^\d+`).FindString(filename)
+// checkStrictOrdering returns an error naming the first pending migration file whose
+// version is lower than the latest applied version
+func checkStrictOrdering(files []string, applied map[string]bool) error {
+	latest := ""
+	for ver := range applied {
+		if ver > latest {
+			latest = ver
+		}
+	}
+
+	for _, filename := range files {
+		ver := migrationVersion(filename)
+		if !applied[ver] && ver < latest {
+			return fmt.Errorf("%s: version is older than the latest applied migration %s "+
+				"(regenerate its timestamp so that it is applied last)", filename, latest)
+		}
+	}
+
+	return nil
 }
 
-// Rollback rolls back the most recent migration
-func (db *DB) Rollback() error {
+// rollbackVersions rolls back the applied migrations returned by selectVersions, in
+// the order returned, and returns how many were rolled back. The schema file is
+// updated afterwards if dump is true.
+func (db *DB) rollbackVersions(ctx context.Context, dump bool,
+	selectVersions func(Driver, *sql.DB) ([]string, error)) (count int, err error) {
+	if err := db.checkSchemaDriver(); err != nil {
+		return 0, err
+	}
+
 	if db.WaitBefore {
-		err := db.Wait()
+		err := db.WaitContext(ctx)
 		if err != nil {
-			return err
+			return 0, err
 		}
 	}
 
-	drv, sqlDB, err := db.openDatabaseForMigration()
+	drv, sqlDB, unlock, err := db.openLockedDatabaseForMigration(ctx)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer mustClose(sqlDB)
+	defer unlock()
+
+	if err := db.checkMigrationsUnlocked(drv, sqlDB); err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		// keep the version file in sync with the migrations rolled back before a failure
+		if err != nil && count == 0 {
+			return
+		}
+		if versionErr := db.writeVersionFile(drv, sqlDB); versionErr != nil && err == nil {
+			err = versionErr
+		}
+	}()
+
+	useNative := db.useNativeEngine(drv)
+
+	versions, err := selectVersions(drv, sqlDB)
+	if err != nil {
+		return 0, err
+	}
 
-	useNative := db.NativeEngine && db.DatabaseURL.Scheme != "oracle"
+	if err := db.checkDataLoss(versions); err != nil {
+		return 0, err
+	}
+
+	for i, ver := range versions {
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
+		if err := db.rollbackMigration(ctx, drv, sqlDB, ver, useNative); err != nil {
+			return i, err
+		}
+	}
+
+	// automatically update schema file, silence errors
+	if dump && !db.DryRun {
+		_ = db.DumpSchemaContext(ctx)
+	}
+
+	return len(versions), nil
+}
+
+// checkDataLoss returns an error if one of the migrations to roll back declares that
+// its down block loses data, unless ConfirmDataLoss is set
+func (db *DB) checkDataLoss(versions []string) error {
+	if db.ConfirmDataLoss {
+		return nil
+	}
+
+	for _, ver := range versions {
+		if _, ok := db.lookupGoMigration(ver); ok {
+			continue
+		}
+
+		filename, err := db.findMigrationFile(ver)
+		if err != nil {
+			return err
+		}
+
+		_, down, err := db.loadMigration(filename)
+		if err != nil {
+			return err
+		}
+
+		if down.Options.DataLoss() {
+			return fmt.Errorf("%s: down migration is declared with data_loss:true "+
+				"(confirm the data loss with --confirm-data-loss to roll it back)", filename)
+		}
+	}
+
+	return nil
+}
+
+// writeVersionFile writes the most recent applied migration version to VersionFile
+// (if set). The file is empty when no migrations have been applied.
+func (db *DB) writeVersionFile(drv Driver, sqlDB *sql.DB) error {
+	if db.VersionFile == "" || db.DryRun {
+		return nil
+	}
 
-	applied, err := drv.SelectMigrations(sqlDB, 1)
+	applied, err := selectAppliedMigrations(drv, sqlDB, 1)
 	if err != nil {
 		return err
 	}
 
-	// grab most recent applied migration (applied has len=1)
-	latest := ""
+	version := ""
 	for ver := range applied {
-		latest = ver
+		version = ver + "\n"
+	}
+
+	// ensure version file directory exists
+	if err := ensureDir(db.fs(), filepath.Dir(db.VersionFile), db.dirMode()); err != nil {
+		return err
 	}
-	if latest == "" {
-		return fmt.Errorf("can't rollback: no migrations have been applied")
+
+	return db.fs().WriteFile(db.VersionFile, []byte(version), db.fileMode())
+}
+
+// rollbackMigration runs the down block of a single applied migration
+// and removes its migration record
+func (db *DB) rollbackMigration(ctx context.Context, drv Driver, sqlDB *sql.DB, ver string,
+	useNative bool) error {
+	filename := goMigrationName(ver)
+	if _, ok := db.lookupGoMigration(ver); !ok {
+		var err error
+		if filename, err = db.findMigrationFile(ver); err != nil {
+			return err
+		}
 	}
 
-	filename, err := findMigrationFile(db.MigrationsDir, latest)
+	_, down, err := db.loadMigration(filename)
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("Rolling back: %s\n", filename)
+	txOpts, err := migrationTxOptions(down, db.DefaultTxOptions)
+	if err != nil {
+		return fmt.Errorf("%s: %s", filename, err)
+	}
 
-	_, down, err := parseMigration(filepath.Join(db.MigrationsDir, filename))
+	nativeEngine, err := migrationNativeEngine(drv, down, useNative)
 	if err != nil {
-		return err
+		return fmt.Errorf("%s: %s", filename, err)
+	}
+	transaction := db.useTransaction(drv, filename, down)
+
+	if db.DryRun {
+		db.printDryRun("roll back", filename, down, transaction)
+		return nil
 	}
 
+	db.logf("Rolling back: %s\n", filename)
+	db.emit(Event{Type: EventRollingBack, Version: ver, Filename: filename})
+	start := time.Now()
+
 	execMigration := func(tx Transaction) error {
+		tx = db.traceTransaction(drv, tx)
+
 		// rollback migration
-		if err := executeScript(tx, down.Contents, useNative); err != nil {
+		err := withSession(tx, down.Options.Session(), func() error {
+			return db.executeMigrationWithHooks(drv, tx, down, nativeEngine,
+				db.BeforeEachRollback, db.AfterEachRollback)
+		})
+		if err != nil {
 			return err
 		}
 
 		// remove migration record
-		return drv.DeleteMigration(tx, latest)
+		if err := drv.DeleteMigration(tx, ver); err != nil {
+			return err
+		}
+
+		return db.auditMigration(drv, tx, ver, "down", true)
 	}
 
-	if down.Options.Transaction() {
+	if transaction {
 		// begin transaction
-		err = doTransaction(sqlDB, execMigration)
+		err = doTransaction(ctx, sqlDB, txOpts, execMigration)
+	} else if len(down.Options.Session()) > 0 {
+		// session statements must run on the same connection as the migration
+		err = doConn(ctx, sqlDB, execMigration)
 	} else {
 		// run outside of transaction
-		err = execMigration(sqlDB)
+		err = execMigration(contextTransaction{ctx: ctx, tx: sqlDB})
 	}
 
 	if err != nil {
+		db.auditFailure(drv, sqlDB, ver, "down")
+		db.emit(Event{Type: EventFailed, Version: ver, Filename: filename,
+			Duration: time.Since(start), Err: err})
 		return err
 	}
 
-	// automatically update schema file, silence errors
-	if db.AutoDumpSchema {
-		_ = db.DumpSchema()
-	}
-
+	db.emit(Event{Type: EventRolledBack, Version: ver, Filename: filename, Duration: time.Since(start)})
 	return nil
 }
 
-func checkMigrationsStatus(db *DB) ([]statusResult, error) {
-	files, err := findMigrationFiles(db.MigrationsDir, migrationFileRegexp)
+func checkMigrationsStatus(ctx context.Context, db *DB) ([]MigrationStatus, error) {
+	files, err := db.findMigrations()
 	if err != nil {
 		return nil, err
 	}
@@ -533,62 +2598,280 @@ func checkMigrationsStatus(db *DB) ([]statusResult, error) {
 		return nil, fmt.Errorf("no migration files found")
 	}
 
-	drv, sqlDB, err := db.openDatabaseForMigration()
+	drv, sqlDB, err := db.openDatabaseForMigration(ctx)
 	if err != nil {
 		return nil, err
 	}
 	defer mustClose(sqlDB)
 
-	applied, err := drv.SelectMigrations(sqlDB, -1)
+	applied, err := selectAppliedMigrations(drv, sqlDB, -1)
 	if err != nil {
 		return nil, err
 	}
 
-	var results []statusResult
+	modified := map[string]bool{}
+	if db.validateChecksums() {
+		if modified, err = db.modifiedMigrations(drv, sqlDB, files); err != nil {
+			return nil, err
+		}
+	}
+
+	results := migrationsStatus(files, applied, modified)
+	if db.RecordTimings {
+		// checked by openDatabaseForMigration
+		if err := addAppliedAt(ctx, drv.(TimingStore), sqlDB, results); err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// addAppliedAt sets the AppliedAt time of the applied migrations which recorded it
+func addAppliedAt(ctx context.Context, store TimingStore, sqlDB *sql.DB, results []MigrationStatus) error {
+	records, err := store.SelectMigrationTimings(ctx, sqlDB)
+	if err != nil {
+		return err
+	}
+
+	appliedAt := map[string]time.Time{}
+	for _, record := range records {
+		if !record.AppliedAt.IsZero() {
+			appliedAt[record.Version] = record.AppliedAt
+		}
+	}
+
+	for i := range results {
+		if t, ok := appliedAt[results[i].Version]; ok && results[i].Applied {
+			results[i].AppliedAt = &t
+		}
+	}
+
+	return nil
+}
+
+// migrationsStatus returns the status of the migration files given the applied
+// versions, sorted by version. Applied versions without a file are reported as orphaned.
+func migrationsStatus(files []string, applied, modified map[string]bool) []MigrationStatus {
+	var results []MigrationStatus
 
 	for _, filename := range files {
 		ver := migrationVersion(filename)
-		res := statusResult{filename: filename}
+		res := MigrationStatus{Version: ver, Filename: filename, Modified: modified[ver]}
 		if ok := applied[ver]; ok {
-			res.applied = true
+			res.Applied = true
 		} else {
-			res.applied = false
+			res.Applied = false
 		}
 
 		results = append(results, res)
+		delete(applied, ver)
 	}
 
-	return results, nil
+	// any remaining applied migrations have no corresponding file
+	for ver := range applied {
+		results = append(results, MigrationStatus{Version: ver, Applied: true, Orphaned: true})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Version < results[j].Version
+	})
+
+	return results
+}
+
+// StatusResults returns the status of all migrations, without printing anything
+func (db *DB) StatusResults() ([]MigrationStatus, error) {
+	return db.StatusResultsContext(context.Background())
+}
+
+// StatusResultsContext is like StatusResults, but gives up as soon as ctx is done
+func (db *DB) StatusResultsContext(ctx context.Context) ([]MigrationStatus, error) {
+	return checkMigrationsStatus(ctx, db)
+}
+
+// StatusFromSchema returns the status of all migrations as recorded in the SQL schema
+// file, without accessing the database, e.g. to check on a build machine that the
+// committed schema file is up to date with the migrations. The applied versions are
+// read from the end of the schema file: every quoted value of the insert statement
+// following the `-- Dbmate schema migrations` line written by DumpSchema is a version.
+// Modified migrations and applied times are not reported.
+func (db *DB) StatusFromSchema() ([]MigrationStatus, error) {
+	format, compressed := schemaFileFormat(db.SchemaFile)
+	if format != schemaFormatSQL {
+		return nil, fmt.Errorf("migrations can only be read from SQL schema files: %s", db.SchemaFile)
+	}
+
+	path := db.migrationsSchemaFile(compressed)
+	applied, ok, err := readSchemaVersions(db.fs(), path, compressed)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("schema file `%s` does not exist or does not record migrations", path)
+	}
+
+	files, err := db.findMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no migration files found")
+	}
+
+	return migrationsStatus(files, applied, map[string]bool{}), nil
+}
+
+// StatusReport is the status of all migrations, along with the number of migrations
+// in each state, as written by StatusJSON
+type StatusReport struct {
+	Migrations []MigrationStatus `json:"migrations"`
+	Applied    int               `json:"applied"`
+	Pending    int               `json:"pending"`
+	Orphaned   int               `json:"orphaned"`
+}
+
+// newStatusReport counts the migrations in each state
+func newStatusReport(results []MigrationStatus) StatusReport {
+	report := StatusReport{Migrations: results}
+	if report.Migrations == nil {
+		report.Migrations = []MigrationStatus{}
+	}
+
+	for _, res := range results {
+		if res.Orphaned {
+			report.Orphaned++
+		} else if res.Applied {
+			report.Applied++
+		} else {
+			report.Pending++
+		}
+	}
+
+	return report
 }
 
 // Status shows the status of all migrations
 func (db *DB) Status(quiet bool) (int, error) {
-	results, err := checkMigrationsStatus(db)
+	return db.StatusContext(context.Background(), quiet)
+}
+
+// StatusContext is like Status, but gives up as soon as ctx is done
+func (db *DB) StatusContext(ctx context.Context, quiet bool) (int, error) {
+	results, err := db.StatusResultsContext(ctx)
 	if err != nil {
 		return -1, err
 	}
 
-	var totalApplied int
-	var line string
+	report := newStatusReport(results)
+	if quiet {
+		return report.Pending, nil
+	}
 
+	now := time.Now()
 	for _, res := range results {
-		if res.applied {
-			line = fmt.Sprintf("[X] %s", res.filename)
-			totalApplied++
-		} else {
-			line = fmt.Sprintf("[ ] %s", res.filename)
+		notes := []string{}
+		if res.Orphaned {
+			notes = append(notes, "no file")
+		}
+		if res.Applied && res.Modified {
+			notes = append(notes, "modified")
+		}
+		if res.AppliedAt != nil {
+			notes = append(notes, "applied "+timeAgo(*res.AppliedAt, now))
+		}
+
+		line := "[ ] " + res.Filename
+		if res.Orphaned {
+			line = "[?] " + res.Version
+		} else if res.Applied {
+			line = "[X] " + res.Filename
+		}
+		if len(notes) > 0 {
+			line += " (" + strings.Join(notes, ", ") + ")"
+		}
+		db.logf("%s\n", line)
+	}
+
+	db.logf("\n")
+	db.logf("Applied: %d\n", report.Applied)
+	db.logf("Pending: %d\n", report.Pending)
+	if report.Orphaned > 0 {
+		db.logf("Orphaned: %d\n", report.Orphaned)
+	}
+
+	return report.Pending, nil
+}
+
+// StatusJSON writes the status of all migrations to w as a JSON StatusReport, for
+// scripts and monitoring tools
+func (db *DB) StatusJSON(w io.Writer) error {
+	return db.StatusJSONContext(context.Background(), w)
+}
+
+// StatusJSONContext is like StatusJSON, but gives up as soon as ctx is done
+func (db *DB) StatusJSONContext(ctx context.Context, w io.Writer) error {
+	results, err := db.StatusResultsContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(newStatusReport(results))
+}
+
+// Verify checks that there are no pending migrations with a version lower than the
+// most recent applied migration. These are typically introduced by merging a branch
+// whose migration has an earlier timestamp than migrations which already ran. With
+// ValidateChecksums, it also checks that no applied migration file was modified.
+func (db *DB) Verify() error {
+	return db.VerifyContext(context.Background())
+}
+
+// VerifyContext is like Verify, but gives up as soon as ctx is done
+func (db *DB) VerifyContext(ctx context.Context) error {
+	results, err := db.StatusResultsContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	latest := ""
+	for _, res := range results {
+		if res.Applied && res.Version > latest {
+			latest = res.Version
 		}
-		if !quiet {
-			fmt.Println(line)
+	}
+
+	var outOfOrder []string
+	for _, res := range results {
+		if !res.Applied && res.Version < latest {
+			outOfOrder = append(outOfOrder, res.Filename)
 		}
 	}
 
-	totalPending := len(results) - totalApplied
-	if !quiet {
-		fmt.Println()
-		fmt.Printf("Applied: %d\n", totalApplied)
-		fmt.Printf("Pending: %d\n", totalPending)
+	if len(outOfOrder) > 0 {
+		return fmt.Errorf("found pending migrations older than the latest applied migration %s: %s",
+			latest, strings.Join(outOfOrder, ", "))
 	}
 
-	return totalPending, nil
+	changed := []string{}
+	for _, res := range results {
+		if res.Applied && res.Modified {
+			changed = append(changed, res.Filename)
+		}
+	}
+	if len(changed) > 0 {
+		return modifiedMigrationsError(changed)
+	}
+
+	return nil
+}
+
+// modifiedMigrationsError reports applied migration files which were modified
+func modifiedMigrationsError(filenames []string) error {
+	return fmt.Errorf("found applied migrations which were modified since they were applied: %s",
+		strings.Join(filenames, ", "))
 }
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd && !windows
+// +build !linux,!darwin,!freebsd,!windows
+
+package dbmate
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// freeDiskSpace is not supported on this platform
+func freeDiskSpace(path string) (uint64, error) {
+	return 0, fmt.Errorf("checking free disk space is not supported on %s", runtime.GOOS)
+}
@@ -1,9 +1,12 @@
 package dbmate
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"net/url"
+	"strings"
+	"time"
 )
 
 // Driver provides top level database functions
@@ -18,8 +21,165 @@ type Driver interface {
 	InsertMigration(Transaction, string) error
 	DeleteMigration(Transaction, string) error
 	Ping(*url.URL) error
+	Capabilities() DriverCapabilities
 }
 
+// DriverCapabilities describes the features supported by a driver
+type DriverCapabilities struct {
+	// TransactionalDDL is true if schema changes are rolled back along with the
+	// transaction which made them
+	TransactionalDDL bool
+	// MultiStatementExec is true if a whole migration script can be executed by a
+	// single Exec call, which is required by the native engine
+	MultiStatementExec bool
+	// ServerAccess is true if DatabaseExists, CreateDatabase and DropDatabase
+	// connect to the database server rather than to the database itself, and
+	// therefore require server level privileges
+	ServerAccess bool
+}
+
+// databaseNamer is implemented by drivers whose database is not named by the path of
+// the URL, so that progress messages show the right name
+type databaseNamer interface {
+	databaseName(*url.URL) string
+}
+
+// SchemaIntrospector is implemented by drivers which can describe the
+// current database schema in a structured form
+type SchemaIntrospector interface {
+	IntrospectSchema(context.Context, *url.URL, *sql.DB) (*Schema, error)
+}
+
+// TableSchemaDumper is implemented by drivers which can dump the schema of each
+// table separately (see DB.DumpSchemaPerTable). The schema of the migrations table
+// includes the applied migrations, like a full schema dump.
+type TableSchemaDumper interface {
+	DumpTableSchemas(*url.URL, *sql.DB) (map[string][]byte, error)
+}
+
+// DiskSpaceChecker is implemented by drivers which store the database on the local
+// filesystem, and can verify that enough disk space is available before migrating
+type DiskSpaceChecker interface {
+	CheckFreeSpace(u *url.URL, minFreeBytes uint64) error
+}
+
+// ReplicaLagChecker is implemented by drivers which can report how far the replicas
+// of the database are behind it (see DB.MaxReplicaLag)
+type ReplicaLagChecker interface {
+	// ReplicaLag returns the replication lag of the slowest replica, or zero if
+	// the database has no replicas
+	ReplicaLag(*sql.DB) (time.Duration, error)
+}
+
+// ForeignKeyChecker is implemented by drivers which can verify that existing rows
+// satisfy the foreign key constraints of the database (see DB.CheckForeignKeys)
+type ForeignKeyChecker interface {
+	// CheckForeignKeys returns a description of each violation found
+	CheckForeignKeys(*sql.DB) ([]string, error)
+}
+
+// PermissionChecker is implemented by drivers which can verify that the connecting
+// role has the privileges needed to apply migrations (see DB.CheckPermissions)
+type PermissionChecker interface {
+	// CheckPermissions returns the missing privileges. Probes which modify the
+	// database run in a transaction which is rolled back.
+	CheckPermissions(context.Context, *sql.DB) ([]string, error)
+}
+
+// ScriptExecutor is implemented by drivers which need control over how a whole
+// migration script is executed by the native engine. Drivers which do not implement
+// it have the script passed to a single Transaction.Exec call.
+type ScriptExecutor interface {
+	ExecScript(tx Transaction, script string) error
+}
+
+// StatementSplitter is implemented by drivers whose scripts are not split on semicolons
+// by the DBMate engine, e.g. SQL Server scripts made of batches separated by `GO`
+// lines. Drivers which do not implement it have scripts split by parseStatements.
+type StatementSplitter interface {
+	SplitStatements(script string) []string
+}
+
+// ContextPinger is implemented by drivers which can give up pinging the database
+// server as soon as a context is done
+type ContextPinger interface {
+	PingContext(context.Context, *url.URL) error
+}
+
+// ChecksumStore is implemented by drivers which can record a checksum of each
+// applied migration in the migrations table (see DB.ValidateChecksums)
+type ChecksumStore interface {
+	// CreateChecksumColumn adds the checksum column to the migrations table,
+	// if it does not already exist
+	CreateChecksumColumn(*sql.DB) error
+	UpdateMigrationChecksum(tx Transaction, version, checksum string) error
+	SelectMigrationChecksums(*sql.DB) (map[string]string, error)
+}
+
+// BatchStore is implemented by drivers which can record the batch of each applied
+// migration in the migrations table (see DB.RecordBatches)
+type BatchStore interface {
+	// CreateBatchColumn adds the batch column to the migrations table,
+	// if it does not already exist
+	CreateBatchColumn(*sql.DB) error
+	UpdateMigrationBatch(tx Transaction, version, batch string) error
+	SelectBatchMigrations(db *sql.DB, batch string) ([]string, error)
+}
+
+// TimingStore is implemented by drivers which can record when each migration was
+// applied, and how long it took, in the migrations table (see DB.RecordTimings)
+type TimingStore interface {
+	// CreateTimingColumns adds the applied_at and duration_ms columns to the
+	// migrations table, if they do not already exist
+	CreateTimingColumns(*sql.DB) error
+	UpdateMigrationTiming(tx Transaction, version string, appliedAt time.Time, duration time.Duration) error
+	// SelectMigrationTimings returns a record for every applied migration
+	SelectMigrationTimings(context.Context, *sql.DB) ([]TimingRecord, error)
+}
+
+// MigrationsTableRenamer is implemented by drivers which can record applied migrations
+// in a table other than schema_migrations (see DB.MigrationsTableName)
+type MigrationsTableRenamer interface {
+	// WithMigrationsTable returns a copy of the driver using the named migrations table
+	WithMigrationsTable(name string) Driver
+}
+
+// NonTransactionalDetector is implemented by drivers which can recognize statements
+// that cannot run inside a transaction (see DB.AutoDetectConcurrent)
+type NonTransactionalDetector interface {
+	// NonTransactionalStatement returns the first statement of a migration script
+	// which cannot run inside a transaction, or an empty string if there is none
+	NonTransactionalStatement(script string) string
+}
+
+// AuditStore is implemented by drivers which can record every attempt to apply or roll
+// back a migration in an append-only audit table (see DB.AuditTableName)
+type AuditStore interface {
+	// CreateAuditTable creates the audit table, if it does not already exist
+	CreateAuditTable(db *sql.DB, table string) error
+	InsertAuditRecord(tx Transaction, table string, record AuditRecord) error
+}
+
+// AdvisoryLocker is implemented by drivers which can prevent concurrent migration runs
+// with a lock held by a database session, see DB.AdvisoryLock
+type AdvisoryLocker interface {
+	// AcquireLock blocks until conn holds the lock of the migrations table, or ctx is done
+	AcquireLock(ctx context.Context, conn *sql.Conn) error
+	// ReleaseLock releases the lock held by conn
+	ReleaseLock(conn *sql.Conn) error
+}
+
+// MigrationBatchInserter is implemented by drivers which can record many migrations
+// at once, e.g. when baselining an existing database. Drivers which do not implement
+// it have InsertMigration called for each version.
+type MigrationBatchInserter interface {
+	InsertMigrations(tx Transaction, versions []string) error
+}
+
+// migrationsBatchSize limits the number of rows inserted by a single statement,
+// to stay below the bind parameter limit of each database (999 for SQLite)
+const migrationsBatchSize = 500
+
 var drivers = map[string]Driver{}
 
 // RegisterDriver registers a driver for a URL scheme
@@ -27,11 +187,161 @@ func RegisterDriver(drv Driver, scheme string) {
 	drivers[scheme] = drv
 }
 
+// pingContext pings the database server, using ctx if the driver supports it
+func pingContext(ctx context.Context, drv Driver, u *url.URL) error {
+	if pinger, ok := drv.(ContextPinger); ok {
+		return pinger.PingContext(ctx, u)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return drv.Ping(u)
+}
+
+// insertMigrations records migration versions, in batches if the driver supports it
+func insertMigrations(drv Driver, tx Transaction, versions []string) error {
+	if inserter, ok := drv.(MigrationBatchInserter); ok {
+		return inserter.InsertMigrations(tx, versions)
+	}
+
+	for _, ver := range versions {
+		if err := drv.InsertMigration(tx, ver); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// insertMigrationBatches inserts versions using multi-row insert statements. The
+// query is the statement up to and including VALUES, and placeholder returns the
+// bind parameter for the row at index i of the batch.
+func insertMigrationBatches(tx Transaction, query string, placeholder func(i int) string,
+	versions []string) error {
+	for start := 0; start < len(versions); start += migrationsBatchSize {
+		end := start + migrationsBatchSize
+		if end > len(versions) {
+			end = len(versions)
+		}
+
+		rows := make([]string, 0, end-start)
+		args := make([]interface{}, 0, end-start)
+		for i, ver := range versions[start:end] {
+			rows = append(rows, "("+placeholder(i)+")")
+			args = append(args, ver)
+		}
+
+		if _, err := tx.Exec(query+" "+strings.Join(rows, ", "), args...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // Transaction can represent a database or open transaction
 type Transaction interface {
 	Exec(query string, args ...interface{}) (sql.Result, error)
 }
 
+// sqlContexter is implemented by *sql.DB, *sql.Tx and *sql.Conn
+type sqlContexter interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// contextTransaction is a Transaction which executes statements with a context
+type contextTransaction struct {
+	ctx context.Context
+	tx  sqlContexter
+}
+
+// Exec executes a statement on the underlying database, connection or transaction
+func (t contextTransaction) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return t.tx.ExecContext(t.ctx, query, args...)
+}
+
+// ExecContext executes a statement on the underlying database, connection or transaction
+func (t contextTransaction) ExecContext(ctx context.Context, query string,
+	args ...interface{}) (sql.Result, error) {
+	return t.tx.ExecContext(ctx, query, args...)
+}
+
+// QueryContext executes a query on the underlying database, connection or transaction
+func (t contextTransaction) QueryContext(ctx context.Context, query string,
+	args ...interface{}) (*sql.Rows, error) {
+	return t.tx.QueryContext(ctx, query, args...)
+}
+
+// QueryRowContext executes a query returning at most one row on the underlying
+// database, connection or transaction
+func (t contextTransaction) QueryRowContext(ctx context.Context, query string,
+	args ...interface{}) *sql.Row {
+	return t.tx.QueryRowContext(ctx, query, args...)
+}
+
+// context returns the context which statements are executed with
+func (t contextTransaction) txContext() context.Context {
+	return t.ctx
+}
+
+// withContext returns a copy of the transaction executing statements with ctx
+func (t contextTransaction) withContext(ctx context.Context) Transaction {
+	return contextTransaction{ctx: ctx, tx: t.tx}
+}
+
+// contextSwitcher is implemented by transactions which execute statements with a
+// context, so that a statement can be executed with a derived context
+type contextSwitcher interface {
+	txContext() context.Context
+	withContext(context.Context) Transaction
+}
+
+// timeoutTransaction is a Transaction which cancels each statement still running after
+// timeout (see DB.StatementTimeout). The underlying transaction must implement
+// contextSwitcher, otherwise statements are executed without a timeout.
+type timeoutTransaction struct {
+	Transaction
+	timeout time.Duration
+}
+
+// Exec executes a statement on the underlying transaction, and returns an error naming
+// the statement if it timed out
+func (t timeoutTransaction) Exec(query string, args ...interface{}) (sql.Result, error) {
+	switcher, ok := t.Transaction.(contextSwitcher)
+	if !ok {
+		return t.Transaction.Exec(query, args...)
+	}
+
+	ctx, cancel := context.WithTimeout(switcher.txContext(), t.timeout)
+	defer cancel()
+
+	result, err := switcher.withContext(ctx).Exec(query, args...)
+	if err != nil && ctx.Err() == context.DeadlineExceeded &&
+		switcher.txContext().Err() == nil {
+		return result, fmt.Errorf("statement timed out after %s: %s", t.timeout,
+			statementSummary(query))
+	}
+
+	return result, err
+}
+
+// statementSummary returns the beginning of a statement on a single line, with
+// credentials masked, so that it can be included in error messages
+func statementSummary(query string) string {
+	const maxLength = 80
+
+	summary := redactSQL(query)
+	if len(summary) > maxLength {
+		summary = summary[:maxLength] + "..."
+	}
+
+	return summary
+}
+
 // GetDriver loads a database driver by name
 func GetDriver(name string) (Driver, error) {
 	if val, ok := drivers[name]; ok {
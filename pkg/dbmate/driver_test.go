@@ -1,6 +1,8 @@
 package dbmate
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -27,8 +29,40 @@ func TestGetDriver_Oracle(t *testing.T) {
 	require.Equal(t, true, ok)
 }
 
+func TestDriverCapabilities(t *testing.T) {
+	require.True(t, PostgresDriver{}.Capabilities().TransactionalDDL)
+	require.False(t, MySQLDriver{}.Capabilities().TransactionalDDL)
+
+	// the native engine is not supported by oracle
+	require.True(t, MySQLDriver{}.Capabilities().MultiStatementExec)
+	require.False(t, OracleDriver{}.Capabilities().MultiStatementExec)
+}
+
 func TestGetDriver_Error(t *testing.T) {
 	drv, err := GetDriver("foo")
 	require.EqualError(t, err, "unsupported driver: foo")
 	require.Nil(t, drv)
 }
+
+func TestInsertMigrations(t *testing.T) {
+	versions := make([]string, 1001)
+	for i := range versions {
+		versions[i] = fmt.Sprintf("%04d", i)
+	}
+
+	// drivers implementing MigrationBatchInserter insert in batches
+	tx := &recordingTransaction{}
+	err := insertMigrations(PostgresDriver{}, tx, versions)
+	require.NoError(t, err)
+	require.Len(t, tx.statements, 3)
+	require.True(t, strings.HasPrefix(tx.statements[0],
+		"insert into public.schema_migrations (version) values ($1), ($2), "))
+	require.True(t, strings.HasSuffix(tx.statements[0], ", ($500)"))
+	require.Equal(t, "insert into public.schema_migrations (version) values ($1)", tx.statements[2])
+
+	// other drivers insert each version
+	tx = &recordingTransaction{}
+	err = insertMigrations(OracleDriver{}, tx, versions[:2])
+	require.NoError(t, err)
+	require.Len(t, tx.statements, 2)
+}
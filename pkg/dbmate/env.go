@@ -0,0 +1,148 @@
+package dbmate
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Environment variables used by NewFromEnv
+const (
+	DatabaseURLEnv   = "DATABASE_URL"
+	MigrationsDirEnv = "DBMATE_MIGRATIONS_DIR"
+	SchemaFileEnv    = "DBMATE_SCHEMA_FILE"
+	NoDumpSchemaEnv  = "DBMATE_NO_DUMP_SCHEMA"
+	WaitEnv          = "DBMATE_WAIT"
+)
+
+// Environment variables used by NewFromEngineEnv
+const (
+	EngineEnv   = "DB_ENGINE"
+	HostEnv     = "DB_HOST"
+	PortEnv     = "DB_PORT"
+	UserEnv     = "DB_USER"
+	PasswordEnv = "DB_PASSWORD"
+	NameEnv     = "DB_NAME"
+	OptionsEnv  = "DB_OPTIONS"
+)
+
+// NewFromEnv initializes a new dbmate database from environment variables. The
+// connection URL is read from DATABASE_URL, and the optional DBMATE_MIGRATIONS_DIR,
+// DBMATE_SCHEMA_FILE, DBMATE_NO_DUMP_SCHEMA and DBMATE_WAIT variables override the
+// defaults of New (the last two are booleans, e.g. `true` or `1`).
+func NewFromEnv() (*DB, error) {
+	value := os.Getenv(DatabaseURLEnv)
+	if value == "" {
+		return nil, fmt.Errorf("%s environment variable is not set", DatabaseURLEnv)
+	}
+
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", DatabaseURLEnv, err)
+	}
+	if _, err := GetDriver(u.Scheme); err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", DatabaseURLEnv, err)
+	}
+
+	db := New(u)
+	if dir := os.Getenv(MigrationsDirEnv); dir != "" {
+		db.MigrationsDir = dir
+	}
+	if file := os.Getenv(SchemaFileEnv); file != "" {
+		db.SchemaFile = file
+	}
+
+	noDumpSchema, err := boolEnv(NoDumpSchemaEnv)
+	if err != nil {
+		return nil, err
+	}
+	db.AutoDumpSchema = !noDumpSchema
+
+	if db.WaitBefore, err = boolEnv(WaitEnv); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// boolEnv parses a boolean environment variable, which is false if unset
+func boolEnv(key string) (bool, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: expected a boolean, got `%s`", key, value)
+	}
+
+	return b, nil
+}
+
+// NewFromEngineEnv initializes a new dbmate database from discrete environment
+// variables, for platforms which do not provide an assembled connection URL.
+// The driver is selected by DB_ENGINE (any registered URL scheme, e.g. `postgres`),
+// and the connection is assembled from DB_HOST, DB_PORT, DB_USER, DB_PASSWORD,
+// DB_NAME and DB_OPTIONS (driver specific options as a query string,
+// e.g. `sslmode=disable`). For SQLite, DB_NAME is the path to the database file.
+func NewFromEngineEnv() (*DB, error) {
+	u, err := databaseURLFromEngineEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	return New(u), nil
+}
+
+// databaseURLFromEngineEnv assembles a database URL from discrete environment variables
+func databaseURLFromEngineEnv() (*url.URL, error) {
+	engine := strings.TrimSpace(os.Getenv(EngineEnv))
+	if engine == "" {
+		return nil, fmt.Errorf("%s environment variable is not set", EngineEnv)
+	}
+
+	// validate engine against registered drivers
+	if _, err := GetDriver(engine); err != nil {
+		return nil, err
+	}
+
+	name := os.Getenv(NameEnv)
+	if name == "" {
+		return nil, fmt.Errorf("%s environment variable is not set", NameEnv)
+	}
+
+	u := &url.URL{
+		Scheme: engine,
+		Path:   "/" + name,
+	}
+
+	options, err := url.ParseQuery(os.Getenv(OptionsEnv))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", OptionsEnv, err)
+	}
+	u.RawQuery = options.Encode()
+
+	if engine == "sqlite" || engine == "sqlite3" {
+		// sqlite databases are files, host and credentials do not apply
+		return u, nil
+	}
+
+	u.Host = os.Getenv(HostEnv)
+	if port := os.Getenv(PortEnv); port != "" {
+		u.Host = net.JoinHostPort(u.Host, port)
+	}
+
+	if user := os.Getenv(UserEnv); user != "" {
+		if password, ok := os.LookupEnv(PasswordEnv); ok {
+			u.User = url.UserPassword(user, password)
+		} else {
+			u.User = url.User(user)
+		}
+	}
+
+	return u, nil
+}
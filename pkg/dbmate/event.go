@@ -0,0 +1,44 @@
+package dbmate
+
+import "time"
+
+// EventType identifies the step of a migration run reported by an Event
+type EventType string
+
+// Event types reported to DB.OnEvent
+const (
+	// EventWaiting is reported once when dbmate starts waiting for the database
+	EventWaiting EventType = "waiting"
+	// EventApplying and EventApplied are reported before and after each migration
+	EventApplying EventType = "applying"
+	EventApplied  EventType = "applied"
+	// EventRollingBack and EventRolledBack are reported before and after each rollback
+	EventRollingBack EventType = "rollingback"
+	EventRolledBack  EventType = "rolledback"
+	// EventFailed is reported when a migration or rollback fails
+	EventFailed EventType = "failed"
+	// EventDumping is reported before each schema file is written
+	EventDumping EventType = "dumping"
+)
+
+// Event describes a step of a migration run, see DB.OnEvent
+type Event struct {
+	Type EventType
+	// Version and Filename identify the migration, for migration events
+	Version  string
+	Filename string
+	// Duration is how long the migration took, for EventApplied, EventRolledBack
+	// and EventFailed
+	Duration time.Duration
+	// Err is the error of EventFailed
+	Err error
+	// Path is the schema file written, for EventDumping
+	Path string
+}
+
+// emit reports event to OnEvent, if set
+func (db *DB) emit(event Event) {
+	if db.OnEvent != nil {
+		db.OnEvent(event)
+	}
+}
@@ -0,0 +1,113 @@
+package dbmate
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+)
+
+// FileSystem is the filesystem which dbmate reads and writes the migration, schema
+// and version files in (see DB.FS). Paths are given in the same form as
+// MigrationsDir, SchemaFile and VersionFile.
+type FileSystem interface {
+	fs.StatFS
+	// MkdirAll creates a directory and its parents, if they do not already exist
+	MkdirAll(dir string, perm os.FileMode) error
+	// WriteFile replaces the contents of a file with data, applying perm exactly.
+	// Readers should never observe a partially written file.
+	WriteFile(name string, data []byte, perm os.FileMode) error
+	// Remove deletes a file
+	Remove(name string) error
+}
+
+// osFS reads the local filesystem. Unlike os.DirFS, it accepts any path, including
+// absolute paths and paths containing `..`, so that MigrationsDir keeps working as
+// a regular path when MigrationsFS is not set.
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+func (osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) MkdirAll(dir string, perm os.FileMode) error {
+	return os.MkdirAll(dir, perm)
+}
+
+func (osFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return writeFileAtomic(name, data, perm)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// fs returns the filesystem which files are read from and written to
+func (db *DB) fs() FileSystem {
+	if db.FS != nil {
+		return db.FS
+	}
+
+	return osFS{}
+}
+
+// migrationsDirs returns the directories listed in MigrationsDir. Empty entries
+// (e.g. from a trailing separator) are ignored.
+func (db *DB) migrationsDirs() []string {
+	dirs := []string{}
+	for _, dir := range filepath.SplitList(db.MigrationsDir) {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	if len(dirs) == 0 {
+		return []string{db.MigrationsDir}
+	}
+
+	return dirs
+}
+
+// migrationsFS returns the filesystem which migration files are read from, and the
+// migrations directories within it. Paths within the filesystem are slash separated.
+func (db *DB) migrationsFS() (fs.FS, []string) {
+	dirs := db.migrationsDirs()
+	for i, dir := range dirs {
+		dirs[i] = filepath.ToSlash(dir)
+		if db.MigrationsFS != nil {
+			// fs.FS paths must not start with `./`
+			dirs[i] = path.Clean(dirs[i])
+		}
+	}
+
+	if db.MigrationsFS != nil {
+		return db.MigrationsFS, dirs
+	}
+
+	return db.fs(), dirs
+}
+
+// migrationFiles returns the sorted names of the migration files matching re in
+// every migrations directory
+func (db *DB) migrationFiles(re *regexp.Regexp) ([]string, error) {
+	fsys, dirs := db.migrationsFS()
+	return findMigrationFilesIn(fsys, dirs, re, db.Recursive)
+}
+
+// migrationPath returns the filesystem and path of a migration file returned by
+// migrationFiles, and the first migrations directory containing it
+func (db *DB) migrationPath(name string) (fs.FS, string, string) {
+	fsys, dirs := db.migrationsFS()
+	for _, dir := range dirs[:len(dirs)-1] {
+		if _, err := fs.Stat(fsys, path.Join(dir, name)); err == nil {
+			return fsys, dir, path.Join(dir, name)
+		}
+	}
+
+	dir := dirs[len(dirs)-1]
+	return fsys, dir, path.Join(dir, name)
+}
@@ -0,0 +1,315 @@
+package dbmate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// GenerateMigration creates a new migration file which changes the current database
+// schema into the schema described by targetSchemaFile, a json schema file as written
+// by DumpSchema (e.g. `db/schema.json`). The down block reverts these changes.
+//
+// This is experimental. Only added and dropped tables and columns are generated as
+// SQL, using the column types reported by the database. Other differences (column
+// types, nullability and indexes) are listed as TODO comments. The generated
+// migration must be reviewed before it is applied.
+func (db *DB) GenerateMigration(targetSchemaFile string) error {
+	return db.GenerateMigrationContext(context.Background(), targetSchemaFile)
+}
+
+// GenerateMigrationContext is like GenerateMigration, but gives up as soon as ctx is done
+func (db *DB) GenerateMigrationContext(ctx context.Context, targetSchemaFile string) error {
+	target, err := readSchemaJSON(db.fs(), targetSchemaFile)
+	if err != nil {
+		return err
+	}
+
+	drv, err := db.GetDriver()
+	if err != nil {
+		return err
+	}
+
+	introspector, ok := drv.(SchemaIntrospector)
+	if !ok {
+		return fmt.Errorf("generating migrations is not supported by driver: %s", db.DatabaseURL.Scheme)
+	}
+
+	u, err := db.connectionURL()
+	if err != nil {
+		return err
+	}
+
+	_, sqlDB, err := db.connectDatabase(ctx)
+	if err != nil {
+		return err
+	}
+	defer mustClose(sqlDB)
+
+	current, err := introspector.IntrospectSchema(ctx, u, sqlDB)
+	if err != nil {
+		return err
+	}
+
+	_, migrationsTable := splitMigrationsTable(db.migrationsTable())
+	up, down := diffSchemas(current, target, migrationsTable)
+	if len(up) == 0 {
+		return fmt.Errorf("database schema already matches `%s`", targetSchemaFile)
+	}
+
+	contents := fmt.Sprintf("-- migrate:up\n-- generated from %s, review before applying\n%s\n\n"+
+		"-- migrate:down\n%s\n", targetSchemaFile, strings.Join(up, "\n"), strings.Join(down, "\n"))
+
+	_, err = db.writeNewMigration("generated", contents)
+	return err
+}
+
+// readSchemaJSON reads a json schema file
+func readSchemaJSON(fsys fs.FS, path string) (*Schema, error) {
+	format, compressed := schemaFileFormat(path)
+	if format != schemaFormatJSON {
+		return nil, fmt.Errorf("schema file `%s` must use the json format", path)
+	}
+
+	data, err := readSchemaFile(fsys, path, compressed)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := &Schema{}
+	if err := json.Unmarshal(data, schema); err != nil {
+		return nil, fmt.Errorf("unable to parse schema file `%s`: %s", path, err)
+	}
+
+	return schema, nil
+}
+
+// diffSchemas returns the statements changing the current schema into the target
+// schema, and the statements reverting them (in reverse order). Differences which
+// cannot be generated are returned as TODO comments in the up statements.
+// The migrations table is ignored.
+func diffSchemas(current, target *Schema, migrationsTable string) ([]string, []string) {
+	currentTables := schemaTablesByName(current, migrationsTable)
+	targetTables := schemaTablesByName(target, migrationsTable)
+
+	up := []string{}
+	down := []string{}
+	for _, name := range sortedTableNames(targetTables) {
+		table := targetTables[name]
+		existing, ok := currentTables[name]
+		if !ok {
+			up = append(up, createTableSQL(table))
+			up = append(up, indexTODOs(table, SchemaTable{})...)
+			down = append(down, fmt.Sprintf("DROP TABLE %s;", name))
+			continue
+		}
+
+		tableUp, tableDown := diffColumns(existing, table)
+		up = append(up, tableUp...)
+		up = append(up, indexTODOs(table, existing)...)
+		down = append(down, tableDown...)
+	}
+
+	for _, name := range sortedTableNames(currentTables) {
+		if _, ok := targetTables[name]; !ok {
+			up = append(up, fmt.Sprintf("DROP TABLE %s;", name))
+			down = append(down, createTableSQL(currentTables[name]))
+		}
+	}
+
+	// revert changes in reverse order
+	for i, j := 0, len(down)-1; i < j; i, j = i+1, j-1 {
+		down[i], down[j] = down[j], down[i]
+	}
+
+	return up, down
+}
+
+// diffColumns returns the statements changing the columns of a table
+func diffColumns(current, target SchemaTable) ([]string, []string) {
+	currentColumns := map[string]SchemaColumn{}
+	for _, col := range current.Columns {
+		currentColumns[col.Name] = col
+	}
+	targetColumns := map[string]bool{}
+
+	up := []string{}
+	down := []string{}
+	for _, col := range target.Columns {
+		targetColumns[col.Name] = true
+
+		existing, ok := currentColumns[col.Name]
+		if !ok {
+			up = append(up, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s;", target.Name, columnSQL(col)))
+			down = append(down, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;", target.Name, col.Name))
+			continue
+		}
+
+		if !strings.EqualFold(existing.Type, col.Type) {
+			up = append(up, fmt.Sprintf("-- TODO: change type of %s.%s from %s to %s",
+				target.Name, col.Name, existing.Type, col.Type))
+		}
+		if existing.Nullable != col.Nullable {
+			nullability := "NOT NULL"
+			if col.Nullable {
+				nullability = "NULL"
+			}
+			up = append(up, fmt.Sprintf("-- TODO: change %s.%s to %s", target.Name, col.Name, nullability))
+		}
+	}
+
+	for _, col := range current.Columns {
+		if !targetColumns[col.Name] {
+			up = append(up, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;", target.Name, col.Name))
+			down = append(down, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s;", target.Name, columnSQL(col)))
+		}
+	}
+
+	return up, down
+}
+
+// indexTODOs lists the indexes which differ between two versions of a table
+func indexTODOs(target, current SchemaTable) []string {
+	currentIndexes := map[string]bool{}
+	for _, idx := range current.Indexes {
+		currentIndexes[idx.Name] = true
+	}
+	targetIndexes := map[string]bool{}
+
+	todos := []string{}
+	for _, idx := range target.Indexes {
+		targetIndexes[idx.Name] = true
+		if !currentIndexes[idx.Name] {
+			todos = append(todos, fmt.Sprintf("-- TODO: add index %s on %s (%s)",
+				idx.Name, target.Name, strings.Join(idx.Columns, ", ")))
+		}
+	}
+	for _, idx := range current.Indexes {
+		if !targetIndexes[idx.Name] {
+			todos = append(todos, fmt.Sprintf("-- TODO: drop index %s on %s", idx.Name, target.Name))
+		}
+	}
+
+	return todos
+}
+
+func createTableSQL(table SchemaTable) string {
+	columns := make([]string, len(table.Columns))
+	for i, col := range table.Columns {
+		columns[i] = "  " + columnSQL(col)
+	}
+
+	return fmt.Sprintf("CREATE TABLE %s (\n%s\n);", table.Name, strings.Join(columns, ",\n"))
+}
+
+func columnSQL(col SchemaColumn) string {
+	if col.Nullable {
+		return fmt.Sprintf("%s %s", col.Name, col.Type)
+	}
+
+	return fmt.Sprintf("%s %s NOT NULL", col.Name, col.Type)
+}
+
+func schemaTablesByName(schema *Schema, migrationsTable string) map[string]SchemaTable {
+	tables := map[string]SchemaTable{}
+	for _, table := range schema.Tables {
+		if table.Name != migrationsTable {
+			tables[table.Name] = table
+		}
+	}
+
+	return tables
+}
+
+func sortedTableNames(tables map[string]SchemaTable) []string {
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// NewMigrationFromDiff creates a new migration file from the changes made to the
+// database since the SQL schema file was last written: its up block contains the
+// CREATE, ALTER and COMMENT statements of the current schema dump which are not in
+// the schema file, e.g. after experimenting with a local database. The down block is
+// left as a TODO. This is a best-effort line-based diff: changed statements appear
+// in their new form only, and the generated migration must be reviewed.
+func (db *DB) NewMigrationFromDiff(name string) error {
+	return db.NewMigrationFromDiffContext(context.Background(), name)
+}
+
+// NewMigrationFromDiffContext is like NewMigrationFromDiff, but gives up as soon as ctx
+// is done
+func (db *DB) NewMigrationFromDiffContext(ctx context.Context, name string) error {
+	format, compressed := schemaFileFormat(db.SchemaFile)
+	if format != schemaFormatSQL || db.DumpSchemaPerTable {
+		return fmt.Errorf("migrations can only be generated from a single SQL schema file")
+	}
+
+	previous, err := readSchemaFile(db.fs(), db.SchemaFile, compressed)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("schema file does not exist: %s", db.SchemaFile)
+	} else if err != nil {
+		return err
+	}
+
+	var current bytes.Buffer
+	if err := db.DumpSchemaToContext(ctx, &current); err != nil {
+		return err
+	}
+
+	existing := map[string]bool{}
+	for _, stmt := range schemaStatements(string(previous)) {
+		existing[stmt] = true
+	}
+
+	up := []string{}
+	for _, stmt := range schemaStatements(current.String()) {
+		if !existing[stmt] && schemaChangeRegExp.MatchString(stmt) {
+			up = append(up, stmt+";")
+		}
+	}
+	if len(up) == 0 {
+		return fmt.Errorf("database schema already matches `%s`", db.SchemaFile)
+	}
+
+	contents := fmt.Sprintf("-- migrate:up\n-- generated from the changes since %s was written, "+
+		"review before applying\n%s\n\n-- migrate:down\n-- TODO: revert the changes above\n",
+		db.SchemaFile, strings.Join(up, "\n\n"))
+
+	_, err = db.writeNewMigration(name, contents)
+	return err
+}
+
+// schemaChangeRegExp matches the statements of a schema dump which change the schema
+var schemaChangeRegExp = regexp.MustCompile(`(?i)^(create|alter|comment)\s`)
+
+// schemaStatements returns the statements of a SQL schema dump, without comments and
+// with trailing whitespace removed from each line, so that dumps can be compared
+func schemaStatements(schema string) []string {
+	statements := []string{}
+	for _, stmt := range parseStatements(schema) {
+		lines := []string{}
+		for _, line := range strings.Split(stmt, "\n") {
+			line = strings.TrimRight(line, " \t\r")
+			if line != "" && !strings.HasPrefix(strings.TrimSpace(line), "--") {
+				lines = append(lines, line)
+			}
+		}
+
+		if len(lines) > 0 {
+			statements = append(statements, strings.Join(lines, "\n"))
+		}
+	}
+
+	return statements
+}
@@ -0,0 +1,150 @@
+package dbmate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffSchemas(t *testing.T) {
+	current := &Schema{Tables: []SchemaTable{
+		{Name: "schema_migrations", Columns: []SchemaColumn{{Name: "version", Type: "varchar(255)"}}},
+		{Name: "legacy", Columns: []SchemaColumn{{Name: "id", Type: "integer"}}},
+		{Name: "users", Columns: []SchemaColumn{
+			{Name: "id", Type: "integer"},
+			{Name: "name", Type: "varchar(255)", Nullable: true},
+			{Name: "nickname", Type: "text", Nullable: true},
+		}, Indexes: []SchemaIndex{{Name: "users_nickname", Columns: []string{"nickname"}}}},
+	}}
+	target := &Schema{Tables: []SchemaTable{
+		{Name: "posts", Columns: []SchemaColumn{
+			{Name: "id", Type: "integer"},
+			{Name: "title", Type: "text", Nullable: true},
+		}},
+		{Name: "users", Columns: []SchemaColumn{
+			{Name: "id", Type: "integer"},
+			{Name: "name", Type: "text"},
+			{Name: "email", Type: "text", Nullable: true},
+		}, Indexes: []SchemaIndex{{Name: "users_email", Unique: true, Columns: []string{"email"}}}},
+	}}
+
+	up, down := diffSchemas(current, target, migrationsTableName)
+	require.Equal(t, []string{
+		"CREATE TABLE posts (\n  id integer NOT NULL,\n  title text\n);",
+		"-- TODO: change type of users.name from varchar(255) to text",
+		"-- TODO: change users.name to NOT NULL",
+		"ALTER TABLE users ADD COLUMN email text;",
+		"ALTER TABLE users DROP COLUMN nickname;",
+		"-- TODO: add index users_email on users (email)",
+		"-- TODO: drop index users_nickname on users",
+		"DROP TABLE legacy;",
+	}, up)
+	require.Equal(t, []string{
+		"CREATE TABLE legacy (\n  id integer NOT NULL\n);",
+		"ALTER TABLE users ADD COLUMN nickname text;",
+		"ALTER TABLE users DROP COLUMN email;",
+		"DROP TABLE posts;",
+	}, down)
+
+	// identical schemas have no differences
+	up, down = diffSchemas(target, target, migrationsTableName)
+	require.Empty(t, up)
+	require.Empty(t, down)
+}
+
+func TestGenerateMigration(t *testing.T) {
+	u := sqliteTestURL(t)
+	db := newTestDB(t, u)
+
+	dir, err := ioutil.TempDir("", "dbmate")
+	require.NoError(t, err)
+	defer func() {
+		err := os.RemoveAll(dir)
+		require.NoError(t, err)
+	}()
+
+	// dump the target schema from the migrated database
+	db.SchemaFile = filepath.Join(dir, "schema.json")
+	err = db.Drop()
+	require.NoError(t, err)
+	err = db.CreateAndMigrate()
+	require.NoError(t, err)
+	err = db.DumpSchema()
+	require.NoError(t, err)
+
+	err = db.GenerateMigration(db.SchemaFile)
+	require.EqualError(t, err, "database schema already matches `"+db.SchemaFile+"`")
+
+	// generate a migration recreating the posts table
+	err = db.Rollback()
+	require.NoError(t, err)
+	db.MigrationsDir = filepath.Join(dir, "migrations")
+	err = db.GenerateMigration(db.SchemaFile)
+	require.NoError(t, err)
+
+	files, err := filepath.Glob(filepath.Join(db.MigrationsDir, "*_generated.sql"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	up, down, err := parseMigration(osFS{}, filepath.Dir(files[0]), files[0])
+	require.NoError(t, err)
+	require.Contains(t, up.Contents, "-- generated from "+db.SchemaFile+", review before applying\n")
+	require.Contains(t, up.Contents, "CREATE TABLE posts (\n  id integer,\n  name varchar(255)\n);")
+	require.Contains(t, down.Contents, "DROP TABLE posts;")
+
+	// only json schema files can be compared
+	err = db.GenerateMigration(filepath.Join(dir, "schema.sql"))
+	require.EqualError(t, err, "schema file `"+filepath.Join(dir, "schema.sql")+"` must use the json format")
+}
+
+func TestNewMigrationFromDiff(t *testing.T) {
+	u := sqliteTestURL(t)
+	db := newTestDB(t, u)
+
+	dir, err := ioutil.TempDir("", "dbmate")
+	require.NoError(t, err)
+	defer func() {
+		err := os.RemoveAll(dir)
+		require.NoError(t, err)
+	}()
+
+	db.SchemaFile = filepath.Join(dir, "schema.sql")
+	err = db.NewMigrationFromDiff("add_tags")
+	require.EqualError(t, err, "schema file does not exist: "+db.SchemaFile)
+
+	err = db.Drop()
+	require.NoError(t, err)
+	err = db.CreateAndMigrate()
+	require.NoError(t, err)
+	err = db.DumpSchema()
+	require.NoError(t, err)
+
+	err = db.NewMigrationFromDiff("add_tags")
+	require.EqualError(t, err, "database schema already matches `"+db.SchemaFile+"`")
+
+	// change the database by hand
+	sqlDB, err := GetDriverOpen(u)
+	require.NoError(t, err)
+	defer mustClose(sqlDB)
+	_, err = sqlDB.Exec("create table tags (id integer, name text)")
+	require.NoError(t, err)
+	_, err = sqlDB.Exec("create index tags_name on tags (name)")
+	require.NoError(t, err)
+
+	db.MigrationsDir = filepath.Join(dir, "migrations")
+	err = db.NewMigrationFromDiff("add_tags")
+	require.NoError(t, err)
+
+	files, err := filepath.Glob(filepath.Join(db.MigrationsDir, "*_add_tags.sql"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	up, down, err := parseMigration(osFS{}, filepath.Dir(files[0]), files[0])
+	require.NoError(t, err)
+	require.Equal(t, "-- migrate:up\n-- generated from the changes since "+db.SchemaFile+
+		" was written, review before applying\n"+
+		"CREATE TABLE tags (id integer, name text);\n\n"+
+		"CREATE INDEX tags_name on tags (name);\n\n", up.Contents)
+	require.Equal(t, "-- migrate:down\n-- TODO: revert the changes above\n", down.Contents)
+}
@@ -0,0 +1,160 @@
+package dbmate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// goMigrationSuffix identifies Go migrations in place of a filename,
+// e.g. "20200301000000 (go)"
+const goMigrationSuffix = " (go)"
+
+var goMigrationVersionRegExp = regexp.MustCompile(`^\d+$`)
+
+// GoMigrationTx is the handle through which a Go migration reads and writes the
+// database. Statements run in the transaction of the migration, and are logged
+// when TraceSQL is enabled.
+type GoMigrationTx interface {
+	Transaction
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// GoMigrationFunc is the up or down function of a Go migration. ctx is done when the
+// Migrate or Rollback call running the migration is canceled.
+type GoMigrationFunc func(ctx context.Context, tx GoMigrationTx) error
+
+type goMigration struct {
+	up   GoMigrationFunc
+	down GoMigrationFunc
+}
+
+var goMigrations = map[string]goMigration{}
+
+// RegisterGoMigration registers a migration implemented in Go. It is applied by
+// Migrate alongside the migration files, ordered by version, and recorded in the
+// migrations table like any other migration. The down function is optional.
+// Like database/sql.Register, it panics if the version is invalid or already
+// registered, so it should be called from an init function.
+func RegisterGoMigration(version string, up, down GoMigrationFunc) {
+	if err := checkGoMigration(version, up); err != nil {
+		panic(fmt.Sprintf("dbmate: %s", err))
+	}
+	if _, ok := goMigrations[version]; ok {
+		panic(fmt.Sprintf("dbmate: Go migration %s is already registered", version))
+	}
+
+	goMigrations[version] = goMigration{up: up, down: down}
+}
+
+// RegisterGoMigration registers a migration implemented in Go for this DB only, while
+// the package level RegisterGoMigration registers it for every DB. The copies of the
+// DB made by MigrateDatabases and ValidateAgainstShadow include it. It returns an
+// error if the version is invalid, or already registered for this DB or every DB.
+func (db *DB) RegisterGoMigration(version string, up, down GoMigrationFunc) error {
+	if err := checkGoMigration(version, up); err != nil {
+		return err
+	}
+	if _, ok := db.lookupGoMigration(version); ok {
+		return fmt.Errorf("Go migration %s is already registered", version)
+	}
+
+	if db.goMigrations == nil {
+		db.goMigrations = map[string]goMigration{}
+	}
+	db.goMigrations[version] = goMigration{up: up, down: down}
+
+	return nil
+}
+
+// checkGoMigration returns an error if a Go migration cannot be registered
+func checkGoMigration(version string, up GoMigrationFunc) error {
+	if !goMigrationVersionRegExp.MatchString(version) {
+		return fmt.Errorf("invalid Go migration version `%s`", version)
+	}
+	if up == nil {
+		return fmt.Errorf("Go migration %s has no up function", version)
+	}
+
+	return nil
+}
+
+// lookupGoMigration returns the Go migration registered with a version, for this DB
+// or for every DB
+func (db *DB) lookupGoMigration(ver string) (goMigration, bool) {
+	if gm, ok := db.goMigrations[ver]; ok {
+		return gm, true
+	}
+
+	gm, ok := goMigrations[ver]
+	return gm, ok
+}
+
+// goMigrationName returns the name used to identify a Go migration
+func goMigrationName(ver string) string {
+	return ver + goMigrationSuffix
+}
+
+// isGoMigration returns whether a migration name refers to a Go migration
+func isGoMigration(name string) bool {
+	return strings.HasSuffix(name, goMigrationSuffix)
+}
+
+// parseGoMigration returns the up and down blocks of a registered Go migration
+func (db *DB) parseGoMigration(ver string) (Migration, Migration) {
+	up := NewMigration()
+	down := NewMigration()
+
+	gm, _ := db.lookupGoMigration(ver)
+	up.run = gm.up
+	down.run = gm.down
+	if down.run == nil {
+		// like a migration file without a down block, rolling back does nothing
+		down.run = func(context.Context, GoMigrationTx) error { return nil }
+	}
+
+	return up, down
+}
+
+// mergeGoMigrations adds the Go migrations registered for this DB or every DB to a
+// sorted list of migration files, keeping it sorted. Go migrations must not share a
+// version with a file.
+func (db *DB) mergeGoMigrations(files []string) ([]string, error) {
+	if len(goMigrations) == 0 && len(db.goMigrations) == 0 {
+		return files, nil
+	}
+
+	versions := map[string]string{}
+	for _, filename := range files {
+		versions[migrationVersion(filename)] = filename
+	}
+
+	registered := map[string]bool{}
+	for ver := range goMigrations {
+		registered[ver] = true
+	}
+	for ver := range db.goMigrations {
+		if registered[ver] {
+			// registered for every DB after being registered for this DB
+			return nil, fmt.Errorf("duplicate Go migration version %s", ver)
+		}
+		registered[ver] = true
+	}
+
+	merged := append([]string{}, files...)
+	for ver := range registered {
+		if filename, ok := versions[ver]; ok {
+			return nil, fmt.Errorf("duplicate migration version %s: `%s` and a Go migration", ver, filename)
+		}
+
+		merged = append(merged, goMigrationName(ver))
+	}
+
+	sortMigrationFiles(merged)
+
+	return merged, nil
+}
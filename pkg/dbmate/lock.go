@@ -0,0 +1,233 @@
+package dbmate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// migrationsLockVersion is the version of the row which LockMigrations inserts in the
+// migrations table. It sorts before the versions of migrations, and is never reported
+// as an applied migration.
+const migrationsLockVersion = "0_dbmate_locked"
+
+// LockMigrations prevents migrations from being applied or rolled back until
+// UnlockMigrations is called, e.g. during a maintenance window. The lock is recorded
+// as a row of the migrations table, so it applies to every dbmate process using the
+// database, and persists until it is explicitly removed.
+func (db *DB) LockMigrations() error {
+	return db.LockMigrationsContext(context.Background())
+}
+
+// LockMigrationsContext is like LockMigrations, but gives up as soon as ctx is done
+func (db *DB) LockMigrationsContext(ctx context.Context) error {
+	return db.setMigrationsLock(ctx, true)
+}
+
+// UnlockMigrations removes the lock set by LockMigrations
+func (db *DB) UnlockMigrations() error {
+	return db.UnlockMigrationsContext(context.Background())
+}
+
+// UnlockMigrationsContext is like UnlockMigrations, but gives up as soon as ctx is done
+func (db *DB) UnlockMigrationsContext(ctx context.Context) error {
+	return db.setMigrationsLock(ctx, false)
+}
+
+// setMigrationsLock inserts or deletes the lock row of the migrations table
+func (db *DB) setMigrationsLock(ctx context.Context, lock bool) error {
+	if db.WaitBefore {
+		err := db.WaitContext(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	drv, sqlDB, err := db.openDatabaseForMigration(ctx)
+	if err != nil {
+		return err
+	}
+	defer mustClose(sqlDB)
+
+	locked, err := migrationsLocked(drv, sqlDB)
+	if err != nil {
+		return err
+	}
+
+	if locked == lock {
+		if lock {
+			db.logf("Migrations are already locked\n")
+		} else {
+			db.logf("Migrations are not locked\n")
+		}
+		return nil
+	}
+
+	if db.DryRun {
+		return nil
+	}
+
+	err = doTransaction(ctx, sqlDB, nil, func(tx Transaction) error {
+		tx = db.traceTransaction(drv, tx)
+		if lock {
+			return drv.InsertMigration(tx, migrationsLockVersion)
+		}
+		return drv.DeleteMigration(tx, migrationsLockVersion)
+	})
+	if err != nil {
+		return err
+	}
+
+	if lock {
+		db.logf("Locked migrations\n")
+	} else {
+		db.logf("Unlocked migrations\n")
+	}
+
+	return nil
+}
+
+// migrationsLocked returns whether the migrations table contains the lock row
+func migrationsLocked(drv Driver, sqlDB *sql.DB) (bool, error) {
+	applied, err := drv.SelectMigrations(sqlDB, -1)
+	if err != nil {
+		return false, err
+	}
+
+	return applied[migrationsLockVersion], nil
+}
+
+// checkMigrationsUnlocked returns an error if migrations are locked by LockMigrations.
+// During a dry run the migrations table may not exist yet, in which case migrations
+// are not locked.
+func (db *DB) checkMigrationsUnlocked(drv Driver, sqlDB *sql.DB) error {
+	locked, err := migrationsLocked(drv, sqlDB)
+	if err != nil {
+		if db.DryRun {
+			return nil
+		}
+		return err
+	}
+
+	if locked {
+		return fmt.Errorf("migrations are locked for maintenance (run `dbmate unlock` to unlock them)")
+	}
+
+	return nil
+}
+
+// selectAppliedMigrations returns the applied migrations with an optional limit,
+// excluding the lock row of the migrations table
+func selectAppliedMigrations(drv Driver, sqlDB *sql.DB, limit int) (map[string]bool, error) {
+	applied, err := drv.SelectMigrations(sqlDB, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	delete(applied, migrationsLockVersion)
+	return applied, nil
+}
+
+// acquireAdvisoryLock waits for the advisory lock of the migrations table when
+// AdvisoryLock is set, and returns a function releasing it
+func (db *DB) acquireAdvisoryLock(ctx context.Context, drv Driver, sqlDB *sql.DB) (func(), error) {
+	if !db.AdvisoryLock || db.DryRun {
+		return func() {}, nil
+	}
+
+	locker, ok := drv.(AdvisoryLocker)
+	if !ok {
+		return nil, fmt.Errorf("advisory locks are not supported by driver: %s",
+			db.DatabaseURL.Scheme)
+	}
+
+	// the lock belongs to a session, so it is held by a dedicated connection
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	lockCtx := ctx
+	if db.LockTimeout > 0 {
+		var cancel context.CancelFunc
+		lockCtx, cancel = context.WithTimeout(ctx, db.LockTimeout)
+		defer cancel()
+	}
+
+	if err := locker.AcquireLock(lockCtx, conn); err != nil {
+		_ = conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if lockCtx.Err() != nil || err == context.DeadlineExceeded {
+			return nil, fmt.Errorf("unable to acquire the migrations lock within %s", db.LockTimeout)
+		}
+		return nil, err
+	}
+	db.debugf("Acquired the migrations lock\n")
+
+	return func() {
+		if err := locker.ReleaseLock(conn); err != nil {
+			db.warnf("Unable to release the migrations lock: %s\n", err)
+		}
+		_ = conn.Close()
+	}, nil
+}
+
+// openLockedDatabaseForMigration is like openDatabaseForMigration, but acquires the
+// advisory lock (see DB.AdvisoryLock) before creating the migrations table and the
+// columns of the enabled options, so that concurrent runs do not race on them either.
+// The returned function releases the lock.
+func (db *DB) openLockedDatabaseForMigration(ctx context.Context) (Driver, *sql.DB, func(), error) {
+	drv, sqlDB, err := db.connectDatabase(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	unlock, err := db.acquireAdvisoryLock(ctx, drv, sqlDB)
+	if err != nil {
+		mustClose(sqlDB)
+		return nil, nil, nil, err
+	}
+
+	if err := db.prepareMigrationsTable(drv, sqlDB); err != nil {
+		unlock()
+		mustClose(sqlDB)
+		return nil, nil, nil, err
+	}
+
+	return drv, sqlDB, unlock, nil
+}
+
+// withAdvisoryLock calls f while holding the advisory lock (see DB.AdvisoryLock), so
+// that several operations run without another dbmate process getting in between.
+// f receives a copy of db which does not acquire the lock again.
+func (db *DB) withAdvisoryLock(ctx context.Context, f func(locked *DB) error) error {
+	if !db.AdvisoryLock || db.DryRun {
+		return f(db)
+	}
+
+	if db.WaitBefore {
+		if err := db.WaitContext(ctx); err != nil {
+			return err
+		}
+	}
+
+	drv, sqlDB, err := db.connectDatabase(ctx)
+	if err != nil {
+		return err
+	}
+	defer mustClose(sqlDB)
+
+	unlock, err := db.acquireAdvisoryLock(ctx, drv, sqlDB)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	locked := *db
+	locked.AdvisoryLock = false
+	locked.WaitBefore = false
+
+	return f(&locked)
+}
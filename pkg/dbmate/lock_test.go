@@ -0,0 +1,152 @@
+package dbmate
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockMigrations(t *testing.T) {
+	u := sqliteTestURL(t)
+	db := newTestDB(t, u)
+	db.AutoDumpSchema = false
+
+	err := db.Drop()
+	require.NoError(t, err)
+	err = db.Create()
+	require.NoError(t, err)
+
+	err = db.LockMigrations()
+	require.NoError(t, err)
+	err = db.LockMigrations()
+	require.NoError(t, err)
+
+	// migrate and rollback refuse to run
+	err = db.Migrate()
+	require.EqualError(t, err, "migrations are locked for maintenance (run `dbmate unlock` to unlock them)")
+	err = db.RollbackN(1)
+	require.EqualError(t, err, "migrations are locked for maintenance (run `dbmate unlock` to unlock them)")
+
+	// the lock row is not reported as a migration
+	results, err := db.StatusResults()
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	require.False(t, results[0].Applied)
+	require.False(t, results[1].Applied)
+
+	err = db.UnlockMigrations()
+	require.NoError(t, err)
+	err = db.Migrate()
+	require.NoError(t, err)
+
+	// the lock persists in the migrations table
+	err = db.LockMigrations()
+	require.NoError(t, err)
+	sqlDB, err := GetDriverOpen(u)
+	require.NoError(t, err)
+	defer mustClose(sqlDB)
+	count := 0
+	err = sqlDB.QueryRow("select count(*) from schema_migrations").Scan(&count)
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+
+	err = db.Rollback()
+	require.Error(t, err)
+	err = db.UnlockMigrations()
+	require.NoError(t, err)
+	err = db.Rollback()
+	require.NoError(t, err)
+
+	results, err = db.StatusResults()
+	require.NoError(t, err)
+	require.True(t, results[0].Applied)
+	require.False(t, results[1].Applied)
+}
+
+// channelLocker is a SQLite driver with an in-process advisory lock
+type channelLocker struct {
+	SQLiteDriver
+	lock     chan struct{}
+	acquired int
+}
+
+func (drv *channelLocker) AcquireLock(ctx context.Context, conn *sql.Conn) error {
+	select {
+	case drv.lock <- struct{}{}:
+		drv.acquired++
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (drv *channelLocker) ReleaseLock(conn *sql.Conn) error {
+	<-drv.lock
+	return nil
+}
+
+func TestAdvisoryLock(t *testing.T) {
+	u := sqliteTestURL(t)
+	db := newTestDB(t, u)
+	db.AdvisoryLock = true
+	db.LockTimeout = 50 * time.Millisecond
+
+	err := db.Drop()
+	require.NoError(t, err)
+	err = db.Migrate()
+	require.EqualError(t, err, "advisory locks are not supported by driver: sqlite3")
+
+	locker := &channelLocker{lock: make(chan struct{}, 1)}
+	RegisterDriver(locker, "sqlite-lock")
+	defer delete(drivers, "sqlite-lock")
+	u.Scheme = "sqlite-lock"
+
+	// another process holds the lock
+	locker.lock <- struct{}{}
+	err = db.Migrate()
+	require.EqualError(t, err, "unable to acquire the migrations lock within 50ms")
+
+	// the migrations table is only created once the lock is acquired
+	sqlDB, err := GetDriverOpen(u)
+	require.NoError(t, err)
+	defer mustClose(sqlDB)
+	count := 0
+	err = sqlDB.QueryRow("select count(*) from sqlite_master where name = 'schema_migrations'").
+		Scan(&count)
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+
+	results, err := db.StatusResults()
+	require.NoError(t, err)
+	require.False(t, results[0].Applied)
+
+	// the lock is held while migrating, and released afterwards
+	<-locker.lock
+	err = db.Migrate()
+	require.NoError(t, err)
+	err = db.Rollback()
+	require.NoError(t, err)
+	require.Equal(t, 2, locker.acquired)
+	require.Len(t, locker.lock, 0)
+
+	results, err = db.StatusResults()
+	require.NoError(t, err)
+	require.True(t, results[0].Applied)
+	require.False(t, results[1].Applied)
+
+	// MigrateTo holds a single lock while rolling back and migrating
+	err = db.Migrate()
+	require.NoError(t, err)
+	err = db.MigrateTo("20151129054053")
+	require.NoError(t, err)
+	require.Equal(t, 4, locker.acquired)
+	require.Len(t, locker.lock, 0)
+
+	results, err = db.StatusResults()
+	require.NoError(t, err)
+	require.True(t, results[0].Applied)
+	require.False(t, results[1].Applied)
+}
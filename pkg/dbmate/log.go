@@ -0,0 +1,174 @@
+package dbmate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel is the severity of a message passed to a Logger
+type LogLevel int
+
+// Log levels, from the most to the least verbose
+const (
+	// LogDebug is used for the messages enabled by Verbose and TraceSQL
+	LogDebug LogLevel = iota
+	// LogInfo is used for progress messages, e.g. each migration being applied
+	LogInfo
+	// LogWarn is used for problems which do not stop dbmate, e.g. a modified migration
+	LogWarn
+	// LogError is used for failures which are reported without stopping dbmate, e.g.
+	// a failed migration with ContinueOnError
+	LogError
+)
+
+// String returns the name of the level, e.g. "info"
+func (l LogLevel) String() string {
+	switch l {
+	case LogDebug:
+		return "debug"
+	case LogInfo:
+		return "info"
+	case LogWarn:
+		return "warn"
+	case LogError:
+		return "error"
+	}
+
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// Logger receives the messages logged by dbmate, see DB.Logger. A message may be
+// part of a line (e.g. the dots printed while waiting for the database), and a line
+// ends with a message ending with a newline. A Logger used by MigrateDatabases must
+// be safe for concurrent use; it receives the messages of each line of a database
+// together.
+type Logger interface {
+	Log(level LogLevel, msg string)
+}
+
+// textLogger writes messages to w unchanged, see NewTextLogger
+type textLogger struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level LogLevel
+}
+
+// NewTextLogger returns a Logger which writes messages of at least the given level
+// to w, as plain text
+func NewTextLogger(w io.Writer, level LogLevel) Logger {
+	return &textLogger{w: w, level: level}
+}
+
+func (l *textLogger) Log(level LogLevel, msg string) {
+	if level < l.level {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = io.WriteString(l.w, msg)
+}
+
+// jsonLogger writes a JSON object for each line, see NewJSONLogger
+type jsonLogger struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level LogLevel
+	now   func() time.Time
+	// line holds the messages of the current line until it ends
+	line      strings.Builder
+	lineLevel LogLevel
+}
+
+// jsonRecord is a line written by a JSON logger
+type jsonRecord struct {
+	Time  string `json:"time"`
+	Level string `json:"level"`
+	Msg   string `json:"msg"`
+}
+
+// NewJSONLogger returns a Logger which writes messages of at least the given level to
+// w as JSON objects, one per line, e.g. {"time":"...","level":"info","msg":"Applying:
+// 20151127184807_create_users_table.sql"}. Messages which are part of a line are
+// written once the line ends, blank lines are skipped.
+func NewJSONLogger(w io.Writer, level LogLevel) Logger {
+	return &jsonLogger{w: w, level: level, now: time.Now}
+}
+
+func (l *jsonLogger) Log(level LogLevel, msg string) {
+	if level < l.level {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.line.Len() == 0 || level > l.lineLevel {
+		l.lineLevel = level
+	}
+	l.line.WriteString(msg)
+
+	// write everything up to the last newline, keeping a partial line for later
+	text := l.line.String()
+	end := strings.LastIndex(text, "\n")
+	if end < 0 {
+		return
+	}
+	l.line.Reset()
+	l.line.WriteString(text[end+1:])
+
+	text = strings.TrimSpace(text[:end])
+	if text == "" {
+		return
+	}
+
+	data, err := json.Marshal(jsonRecord{
+		Time:  l.now().UTC().Format(time.RFC3339Nano),
+		Level: l.lineLevel.String(),
+		Msg:   text,
+	})
+	if err != nil {
+		return
+	}
+	_, _ = l.w.Write(append(data, '\n'))
+}
+
+// logAt passes a message to Logger, or writes it to Log when Logger is not set
+func (db *DB) logAt(level LogLevel, format string, args ...interface{}) {
+	if db.Logger != nil {
+		db.Logger.Log(level, fmt.Sprintf(format, args...))
+		return
+	}
+
+	w := db.Log
+	if w == nil {
+		w = os.Stdout
+	}
+
+	fmt.Fprintf(w, format, args...)
+}
+
+// logf logs a progress message
+func (db *DB) logf(format string, args ...interface{}) {
+	db.logAt(LogInfo, format, args...)
+}
+
+// debugf logs a message enabled by Verbose or TraceSQL
+func (db *DB) debugf(format string, args ...interface{}) {
+	db.logAt(LogDebug, format, args...)
+}
+
+// warnf logs a problem which does not stop dbmate
+func (db *DB) warnf(format string, args ...interface{}) {
+	db.logAt(LogWarn, format, args...)
+}
+
+// errorf logs a failure which is reported without stopping dbmate
+func (db *DB) errorf(format string, args ...interface{}) {
+	db.logAt(LogError, format, args...)
+}
@@ -1,8 +1,10 @@
 package dbmate
 
 import (
+	"database/sql"
 	"fmt"
-	"io/ioutil"
+	"io/fs"
+	"path"
 	"regexp"
 	"strings"
 )
@@ -10,6 +12,12 @@ import (
 // MigrationOptions is an interface for accessing migration options
 type MigrationOptions interface {
 	Transaction() bool
+	TransactionAuto() bool
+	Session() []string
+	Isolation() string
+	Irreversible() bool
+	DataLoss() bool
+	Engine() string
 }
 
 type migrationOptions map[string]string
@@ -20,10 +28,88 @@ func (m migrationOptions) Transaction() bool {
 	return m["transaction"] != "false"
 }
 
+// TransactionAuto returns whether this migration should run in a transaction only if
+// the driver supports transactional DDL, with `transaction:auto`, e.g. for migrations
+// shared by PostgreSQL and MySQL
+func (m migrationOptions) TransactionAuto() bool {
+	return m["transaction"] == "auto"
+}
+
+// Session returns the session statements which should be executed before this
+// migration, and reset after it, e.g. `session:"SET search_path TO tenant_a"`.
+// The option may be repeated to set several session variables.
+func (m migrationOptions) Session() []string {
+	if m["session"] == "" {
+		return nil
+	}
+
+	return strings.Split(m["session"], "\n")
+}
+
+// Isolation returns the isolation level of the migration transaction, e.g.
+// `isolation:serializable`, or an empty string to use the default level.
+func (m migrationOptions) Isolation() string {
+	return m["isolation"]
+}
+
+// Irreversible returns whether a down block is declared to intentionally do
+// nothing, with `-- migrate:down irreversible:true` (see DB.RequireDown)
+func (m migrationOptions) Irreversible() bool {
+	return m["irreversible"] == "true"
+}
+
+// DataLoss returns whether a down block is declared to not restore the data removed by
+// the up block, with `-- migrate:down data_loss:true` (see DB.ConfirmDataLoss)
+func (m migrationOptions) DataLoss() bool {
+	return m["data_loss"] == "true"
+}
+
+// Engine returns the engine which should execute this migration, `engine:dbmate` or
+// `engine:native`, or an empty string to use the engine chosen by DB.NativeEngine
+func (m migrationOptions) Engine() string {
+	return m["engine"]
+}
+
+// isolationLevels maps the values of the isolation option to sql isolation levels
+var isolationLevels = map[string]sql.IsolationLevel{
+	"default":          sql.LevelDefault,
+	"read_uncommitted": sql.LevelReadUncommitted,
+	"read_committed":   sql.LevelReadCommitted,
+	"write_committed":  sql.LevelWriteCommitted,
+	"repeatable_read":  sql.LevelRepeatableRead,
+	"snapshot":         sql.LevelSnapshot,
+	"serializable":     sql.LevelSerializable,
+	"linearizable":     sql.LevelLinearizable,
+}
+
+// migrationTxOptions returns the options of the transaction running a migration.
+// The isolation option of the migration takes precedence over the defaults.
+func migrationTxOptions(migration Migration, defaults *sql.TxOptions) (*sql.TxOptions, error) {
+	level := migration.Options.Isolation()
+	if level == "" {
+		return defaults, nil
+	}
+
+	isolation, ok := isolationLevels[strings.ToLower(level)]
+	if !ok {
+		return nil, fmt.Errorf("invalid isolation level `%s`", level)
+	}
+
+	opts := &sql.TxOptions{Isolation: isolation}
+	if defaults != nil {
+		opts.ReadOnly = defaults.ReadOnly
+	}
+
+	return opts, nil
+}
+
 // Migration contains the migration contents and options
 type Migration struct {
 	Contents string
 	Options  MigrationOptions
+
+	// run is set for Go migrations, and executed instead of Contents
+	run GoMigrationFunc
 }
 
 // NewMigration constructs a Migration object
@@ -31,23 +117,71 @@ func NewMigration() Migration {
 	return Migration{Contents: "", Options: make(migrationOptions)}
 }
 
-// parseMigration reads a migration file and returns (up Migration, down Migration, error)
-func parseMigration(path string) (Migration, Migration, error) {
-	data, err := ioutil.ReadFile(path)
+// parseMigration reads a migration file and returns (up Migration, down Migration, error).
+// Includes are resolved relative to dir, the migrations directory the file was found in,
+// even when the file is in one of its subdirectories.
+func parseMigration(fsys fs.FS, dir, name string) (Migration, Migration, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return NewMigration(), NewMigration(), err
+	}
+	contents, err := resolveIncludes(fsys, string(data), dir, []string{name})
 	if err != nil {
 		return NewMigration(), NewMigration(), err
 	}
-	up, down, err := parseMigrationContents(string(data))
+	up, down, err := parseMigrationContents(contents)
 	return up, down, err
 }
 
-var upRegExp = regexp.MustCompile(`(?m)^--\s*migrate:up(\s*$|\s+\S+)`)
-var downRegExp = regexp.MustCompile(`(?m)^--\s*migrate:down(\s*$|\s+\S+)$`)
+var includeRegExp = regexp.MustCompile(`(?m)^--[ \t]*include:[ \t]*(\S+)[ \t]*$`)
+
+// resolveIncludes replaces every `-- include: file.sql` line in contents with the
+// contents of the referenced file. Paths are resolved relative to dir (the migrations
+// directory), including for directives found inside included files. The stack holds
+// the files currently being resolved, and is used to detect include cycles.
+func resolveIncludes(fsys fs.FS, contents, dir string, stack []string) (string, error) {
+	var err error
+
+	resolved := includeRegExp.ReplaceAllStringFunc(contents, func(directive string) string {
+		if err != nil {
+			return directive
+		}
+
+		file := path.Join(dir, includeRegExp.FindStringSubmatch(directive)[1])
+
+		// copy stack so that sibling includes do not share state
+		chain := append(append([]string{}, stack...), file)
+		for _, parent := range stack {
+			if parent == file {
+				err = fmt.Errorf("include cycle detected: %s", strings.Join(chain, " -> "))
+				return directive
+			}
+		}
+
+		data, readErr := fs.ReadFile(fsys, file)
+		if readErr != nil {
+			err = fmt.Errorf("unable to include `%s`: %s", file, readErr)
+			return directive
+		}
+
+		included, includeErr := resolveIncludes(fsys, strings.TrimRight(string(data), "\n"), dir, chain)
+		if includeErr != nil {
+			err = includeErr
+			return directive
+		}
+
+		return included
+	})
+
+	return resolved, err
+}
+
+var upRegExp = regexp.MustCompile(`(?m)^--\s*migrate:up(\s*$|[ \t]+\S.*$)`)
+var downRegExp = regexp.MustCompile(`(?m)^--\s*migrate:down(\s*$|[ \t]+\S.*$)`)
 var emptyLineRegExp = regexp.MustCompile(`^\s*$`)
 var commentLineRegExp = regexp.MustCompile(`^\s*--`)
-var whitespaceRegExp = regexp.MustCompile(`\s+`)
-var optionSeparatorRegExp = regexp.MustCompile(`:`)
-var blockDirectiveRegExp = regexp.MustCompile(`^--\s*migrate:[up|down]]`)
+var optionRegExp = regexp.MustCompile(`(?:[^\s"]|"[^"]*")+`)
+var blockDirectiveRegExp = regexp.MustCompile(`^--\s*migrate:(up|down)`)
 
 // parseMigrationContents parses the string contents of a migration.
 // It will return two Migration objects, the first representing the "up"
@@ -90,6 +224,18 @@ func parseMigrationContents(contents string) (Migration, Migration, error) {
 	return up, down, nil
 }
 
+// hasStatements returns whether a migration block contains anything other than
+// its directive, comments and blank lines
+func hasStatements(contents string) bool {
+	for _, line := range strings.Split(contents, "\n") {
+		if !emptyLineRegExp.MatchString(line) && !commentLineRegExp.MatchString(line) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // parseMigrationOptions parses the migration options out of a block
 // directive into an object that implements the MigrationOptions interface.
 //
@@ -98,6 +244,8 @@ func parseMigrationContents(contents string) (Migration, Migration, error) {
 //     fmt.Printf("%#v", parseMigrationOptions("-- migrate:up transaction:false"))
 //     // migrationOptions{"transaction": "false"}
 //
+// Values containing whitespace may be double quoted, e.g. `session:"SET foo TO bar"`.
+//
 func parseMigrationOptions(contents string) MigrationOptions {
 	options := make(migrationOptions)
 
@@ -113,16 +261,29 @@ func parseMigrationOptions(contents string) MigrationOptions {
 	}
 
 	// split the options string into pairs, e.g. "transaction:false foo:bar" -> []string{"transaction:false", "foo:bar"}
-	stringPairs := whitespaceRegExp.Split(contents, -1)
+	stringPairs := optionRegExp.FindAllString(contents, -1)
 
 	for _, stringPair := range stringPairs {
 		// split stringified pair into key and value pairs, e.g. "transaction:false" -> []string{"transaction", "false"}
-		pair := optionSeparatorRegExp.Split(stringPair, -1)
+		pair := strings.SplitN(stringPair, ":", 2)
+		if len(pair) != 2 {
+			continue
+		}
+
+		key, value := pair[0], pair[1]
+		if quoted := len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`); quoted {
+			value = value[1 : len(value)-1]
+		} else if strings.Contains(value, ":") {
+			// ignore malformed pairs, e.g. "foo:bar:baz"
+			continue
+		}
 
-		// if the syntax is well-formed, then store the key and value pair in options
-		if len(pair) == 2 {
-			options[pair[0]] = pair[1]
+		// session statements accumulate, other options are overwritten
+		if key == "session" && options[key] != "" {
+			value = options[key] + "\n" + value
 		}
+
+		options[key] = value
 	}
 
 	return options
@@ -1,6 +1,10 @@
 package dbmate
 
 import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -72,6 +76,34 @@ ALTER TYPE colors ADD VALUE 'orange' AFTER 'red';
 	require.Equal(t, "", down.Contents)
 	require.Equal(t, true, down.Options.Transaction())
 
+	// It supports choosing transactions depending on the driver
+	migration = `-- migrate:up transaction:auto
+create table users (id serial);
+`
+
+	up, _, err = parseMigrationContents(migration)
+	require.Nil(t, err)
+
+	require.Equal(t, true, up.Options.Transaction())
+	require.Equal(t, true, up.Options.TransactionAuto())
+
+	// It supports multiple options, quoted values, and repeated session options
+	migration = `-- migrate:up transaction:false session:"SET search_path TO tenant_a" session:"SET x = 'a:b'"
+create table accounts (id serial);
+-- migrate:down session:"SET ROLE admin"
+drop table accounts;
+`
+
+	up, down, err = parseMigrationContents(migration)
+	require.Nil(t, err)
+
+	require.Equal(t, false, up.Options.Transaction())
+	require.Equal(t, []string{"SET search_path TO tenant_a", "SET x = 'a:b'"}, up.Options.Session())
+
+	require.Equal(t, true, down.Options.Transaction())
+	require.Equal(t, []string{"SET ROLE admin"}, down.Options.Session())
+	require.Equal(t, "-- migrate:down session:\"SET ROLE admin\"\ndrop table accounts;\n", down.Contents)
+
 	// It does *not* support omitting the up block.
 	migration = `-- migrate:down
 drop table users;
@@ -130,3 +162,81 @@ ADD COLUMN status status_type DEFAULT 'active';
 	require.NotNil(t, err)
 	require.Equal(t, "dbmate requires each migration to define an up bock with '-- migrate:up'", err.Error())
 }
+
+func TestParseMigrationIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbmate")
+	require.NoError(t, err)
+	defer func() {
+		err := os.RemoveAll(dir)
+		require.NoError(t, err)
+	}()
+
+	writeFile := func(name, contents string) string {
+		path := filepath.Join(dir, name)
+		err := ensureDir(osFS{}, filepath.Dir(path), DefaultDirMode)
+		require.NoError(t, err)
+		err = ioutil.WriteFile(path, []byte(contents), 0644)
+		require.NoError(t, err)
+		return path
+	}
+
+	writeFile("shared/audit.sql", "-- include: shared/columns.sql\ncreate index audit_idx on audit (id);\n")
+	writeFile("shared/columns.sql", "create table audit (id integer);\n")
+	path := writeFile("20200101000000_audit.sql", `-- migrate:up
+-- include: shared/audit.sql
+
+-- migrate:down
+drop table audit;
+`)
+
+	// It inlines included files, resolving nested includes relative to the migrations dir
+	up, down, err := parseMigration(osFS{}, filepath.Dir(path), path)
+	require.NoError(t, err)
+	require.Equal(t, "-- migrate:up\ncreate table audit (id integer);\n"+
+		"create index audit_idx on audit (id);\n\n", up.Contents)
+	require.Equal(t, "-- migrate:down\ndrop table audit;\n", down.Contents)
+
+	// It returns an error when an included file does not exist
+	path = writeFile("20200101000001_missing.sql", "-- migrate:up\n-- include: shared/missing.sql\n")
+	_, _, err = parseMigration(osFS{}, filepath.Dir(path), path)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unable to include")
+
+	// It detects include cycles
+	writeFile("shared/a.sql", "-- include: shared/b.sql\n")
+	writeFile("shared/b.sql", "-- include: shared/a.sql\n")
+	path = writeFile("20200101000002_cycle.sql", "-- migrate:up\n-- include: shared/a.sql\n")
+	_, _, err = parseMigration(osFS{}, filepath.Dir(path), path)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "include cycle detected")
+}
+
+func TestMigrationTxOptions(t *testing.T) {
+	up, _, err := parseMigrationContents("-- migrate:up\ncreate table users (id integer);\n")
+	require.NoError(t, err)
+
+	// It uses the defaults when the migration has no isolation option
+	opts, err := migrationTxOptions(up, nil)
+	require.NoError(t, err)
+	require.Nil(t, opts)
+
+	defaults := &sql.TxOptions{Isolation: sql.LevelReadCommitted, ReadOnly: true}
+	opts, err = migrationTxOptions(up, defaults)
+	require.NoError(t, err)
+	require.Equal(t, defaults, opts)
+
+	// The isolation option takes precedence over the defaults
+	up, _, err = parseMigrationContents("-- migrate:up isolation:Serializable\nupdate users set id = 1;\n")
+	require.NoError(t, err)
+	require.Equal(t, "Serializable", up.Options.Isolation())
+
+	opts, err = migrationTxOptions(up, defaults)
+	require.NoError(t, err)
+	require.Equal(t, &sql.TxOptions{Isolation: sql.LevelSerializable, ReadOnly: true}, opts)
+
+	// It returns an error for unknown isolation levels
+	up, _, err = parseMigrationContents("-- migrate:up isolation:chaos\nupdate users set id = 1;\n")
+	require.NoError(t, err)
+	_, err = migrationTxOptions(up, defaults)
+	require.EqualError(t, err, "invalid isolation level `chaos`")
+}
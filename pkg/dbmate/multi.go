@@ -0,0 +1,145 @@
+package dbmate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// MigrateDatabases applies pending migrations to every database in urls (e.g. the
+// shards of a tenant-per-database deployment), with the settings of db. Up to
+// MaxConcurrency databases are migrated at once. A failure does not stop the other
+// databases: once every database has been attempted, the error lists each database
+// which failed. The schema and version files are never written, and
+// AfterMigrateComplete is not called.
+func (db *DB) MigrateDatabases(urls []*url.URL) error {
+	return db.MigrateDatabasesContext(context.Background(), urls)
+}
+
+// MigrateDatabasesContext is like MigrateDatabases, but stops as soon as ctx is done
+func (db *DB) MigrateDatabasesContext(ctx context.Context, urls []*url.URL) error {
+	if len(urls) == 0 {
+		return fmt.Errorf("no databases to migrate")
+	}
+
+	workers := db.MaxConcurrency
+	if workers <= 0 || workers > len(urls) {
+		workers = len(urls)
+	}
+
+	// progress messages of concurrent migrations share Log (or Logger, which must be
+	// safe for concurrent use, and receives the lines of each database whole)
+	log := &syncWriter{w: db.Log}
+	if log.w == nil {
+		log.w = os.Stdout
+	}
+	var loggerMu sync.Mutex
+
+	errs := make([]error, len(urls))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				shard := *db
+				shard.DatabaseURL = urls[i]
+				shard.Log = log
+				shard.VersionFile = ""
+				shard.AfterMigrateComplete = nil
+				var logger *lineLogger
+				if db.Logger != nil {
+					logger = &lineLogger{mu: &loggerMu, logger: db.Logger}
+					shard.Logger = logger
+				}
+				_, errs[i] = shard.migrate(ctx, nil, false)
+				if logger != nil {
+					logger.end()
+				}
+			}
+		}()
+	}
+	for i := range urls {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	failed := []string{}
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s (%s)", urls[i].Redacted(), err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("unable to migrate %d of %d databases: %s", len(failed), len(urls),
+			strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
+// lineLogger holds the messages of a line until it ends, then passes them to logger
+// together, so that the lines of several lineLoggers sharing mu are not mixed up
+type lineLogger struct {
+	mu     *sync.Mutex
+	logger Logger
+	// pending holds the messages of the current line
+	pending []logMessage
+}
+
+// logMessage is a message held by a lineLogger
+type logMessage struct {
+	level LogLevel
+	msg   string
+}
+
+func (l *lineLogger) Log(level LogLevel, msg string) {
+	l.pending = append(l.pending, logMessage{level: level, msg: msg})
+	if !strings.HasSuffix(msg, "\n") {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, m := range l.pending {
+		l.logger.Log(m.level, m.msg)
+	}
+	l.pending = l.pending[:0]
+}
+
+// end ends the current line, if any, once the database is done. The newline is
+// logged at the highest level of the line, so that it is not filtered out.
+func (l *lineLogger) end() {
+	if len(l.pending) == 0 {
+		return
+	}
+
+	level := l.pending[0].level
+	for _, m := range l.pending {
+		if m.level > level {
+			level = m.level
+		}
+	}
+	l.Log(level, "\n")
+}
+
+// syncWriter serializes writes to an io.Writer shared by several goroutines
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Write writes p to the underlying writer
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.w.Write(p)
+}
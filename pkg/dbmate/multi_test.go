@@ -0,0 +1,88 @@
+package dbmate
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateDatabases(t *testing.T) {
+	u := sqliteTestURL(t)
+	db := newTestDB(t, u)
+	db.MaxConcurrency = 2
+	require.NoError(t, db.Drop())
+
+	urls := []*url.URL{}
+	for i := 0; i < 3; i++ {
+		path := fmt.Sprintf("/tmp/dbmate_shard%d.sqlite3", i)
+		require.NoError(t, os.RemoveAll(path))
+		defer os.Remove(path) // nolint:errcheck
+
+		shardURL, err := url.Parse("sqlite3:///" + path)
+		require.NoError(t, err)
+		urls = append(urls, shardURL)
+	}
+	missing, err := url.Parse("sqlite3:////tmp/dbmate_missing/shard.sqlite3")
+	require.NoError(t, err)
+
+	// the other databases are migrated even though one of them fails
+	err = db.MigrateDatabases(append(urls, missing))
+	require.EqualError(t, err, "unable to migrate 1 of 4 databases: "+
+		"sqlite3:////tmp/dbmate_missing/shard.sqlite3 (unable to open database file)")
+
+	for _, shardURL := range urls {
+		sqlDB, err := GetDriverOpen(shardURL)
+		require.NoError(t, err)
+
+		count := 0
+		err = sqlDB.QueryRow("select count(*) from schema_migrations").Scan(&count)
+		mustClose(sqlDB)
+		require.NoError(t, err)
+		require.Equal(t, 2, count)
+	}
+
+	// the database at DatabaseURL is not touched
+	exists, err := SQLiteDriver{}.DatabaseExists(u)
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestLineLogger(t *testing.T) {
+	var buf bytes.Buffer
+	json := NewJSONLogger(&buf, LogInfo).(*jsonLogger)
+	json.now = func() time.Time {
+		return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	}
+
+	// the partial lines of two databases are not merged
+	var mu sync.Mutex
+	a := &lineLogger{mu: &mu, logger: json}
+	b := &lineLogger{mu: &mu, logger: json}
+	a.Log(LogInfo, "Waiting for database")
+	b.Log(LogInfo, "Applying: 001_users.sql\n")
+	a.Log(LogInfo, ".")
+	a.Log(LogDebug, "ignored")
+	b.Log(LogWarn, "Warning: ")
+	a.Log(LogInfo, "\n")
+	b.Log(LogWarn, "001_users.sql has been modified")
+	require.Equal(t, strings.Join([]string{
+		`{"time":"2020-01-02T03:04:05Z","level":"info","msg":"Applying: 001_users.sql"}`,
+		`{"time":"2020-01-02T03:04:05Z","level":"info","msg":"Waiting for database."}`,
+		"",
+	}, "\n"), buf.String())
+
+	// the line left when the database is done is ended
+	b.end()
+	a.end()
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, 3)
+	require.Equal(t,
+		`{"time":"2020-01-02T03:04:05Z","level":"warn","msg":"Warning: 001_users.sql has been modified"}`, lines[2])
+}
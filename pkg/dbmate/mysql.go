@@ -2,10 +2,13 @@ package dbmate
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"fmt"
+	"math"
 	"net/url"
 	"strings"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql" // mysql driver for database/sql
 )
@@ -16,8 +19,40 @@ func init() {
 
 // MySQLDriver provides top level database functions
 type MySQLDriver struct {
+	tableName string
 }
 
+// WithMigrationsTable returns a copy of the driver recording migrations in the named table
+func (drv MySQLDriver) WithMigrationsTable(name string) Driver {
+	drv.tableName = name
+	return drv
+}
+
+// migrationsTable returns the name of the migrations table, as configured
+func (drv MySQLDriver) migrationsTable() string {
+	if drv.tableName == "" {
+		return migrationsTableName
+	}
+
+	return drv.tableName
+}
+
+// quotedMigrationsTable returns the name of the migrations table, quoted for use in
+// statements. A name qualified with a schema refers to a table of another database.
+func (drv MySQLDriver) quotedMigrationsTable() string {
+	schema, table := splitMigrationsTable(drv.migrationsTable())
+	if schema == "" {
+		return mysqlQuoteIdentifier(table)
+	}
+
+	return mysqlQuoteIdentifier(schema) + "." + mysqlQuoteIdentifier(table)
+}
+
+// mysqlMigrationsTableCondition matches the migrations table in information_schema,
+// given its schema (empty for the current database) and table
+const mysqlMigrationsTableCondition = "table_schema = coalesce(nullif(?, ''), database()) " +
+	"and table_name = ?"
+
 func normalizeMySQLURL(u *url.URL) string {
 	// set default port
 	host := u.Host
@@ -58,7 +93,12 @@ func normalizeMySQLURL(u *url.URL) string {
 
 // Open creates a new database connection
 func (drv MySQLDriver) Open(u *url.URL) (*sql.DB, error) {
-	return sql.Open("mysql", normalizeMySQLURL(u))
+	return sql.Open(drv.dataSourceName(u))
+}
+
+// dataSourceName returns the database/sql driver name and data source name
+func (drv MySQLDriver) dataSourceName(u *url.URL) (string, string) {
+	return "mysql", normalizeMySQLURL(u)
 }
 
 func (drv MySQLDriver) openRootDB(u *url.URL) (*sql.DB, error) {
@@ -78,7 +118,6 @@ func mysqlQuoteIdentifier(str string) string {
 // CreateDatabase creates the specified database
 func (drv MySQLDriver) CreateDatabase(u *url.URL) error {
 	name := databaseName(u)
-	fmt.Printf("Creating: %s\n", name)
 
 	db, err := drv.openRootDB(u)
 	if err != nil {
@@ -95,7 +134,6 @@ func (drv MySQLDriver) CreateDatabase(u *url.URL) error {
 // DropDatabase drops the specified database (if it exists)
 func (drv MySQLDriver) DropDatabase(u *url.URL) error {
 	name := databaseName(u)
-	fmt.Printf("Dropping: %s\n", name)
 
 	db, err := drv.openRootDB(u)
 	if err != nil {
@@ -135,21 +173,21 @@ func mysqldumpArgs(u *url.URL) []string {
 	return args
 }
 
-func mysqlSchemaMigrationsDump(db *sql.DB) ([]byte, error) {
+func mysqlSchemaMigrationsDump(db *sql.DB, table string) ([]byte, error) {
 	// load applied migrations
 	migrations, err := queryColumn(db,
-		"select quote(version) from schema_migrations order by version asc")
+		"select quote(version) from "+table+" order by version asc")
 	if err != nil {
 		return nil, err
 	}
 
-	// build schema_migrations table data
+	// build migrations table data
 	var buf bytes.Buffer
 	buf.WriteString("\n--\n-- Dbmate schema migrations\n--\n\n" +
-		"LOCK TABLES `schema_migrations` WRITE;\n")
+		"LOCK TABLES " + table + " WRITE;\n")
 
 	if len(migrations) > 0 {
-		buf.WriteString("INSERT INTO `schema_migrations` (version) VALUES\n  (" +
+		buf.WriteString("INSERT INTO " + table + " (version) VALUES\n  (" +
 			strings.Join(migrations, "),\n  (") +
 			");\n")
 	}
@@ -166,7 +204,7 @@ func (drv MySQLDriver) DumpSchema(u *url.URL, db *sql.DB) ([]byte, error) {
 		return nil, err
 	}
 
-	migrations, err := mysqlSchemaMigrationsDump(db)
+	migrations, err := mysqlSchemaMigrationsDump(db, drv.quotedMigrationsTable())
 	if err != nil {
 		return nil, err
 	}
@@ -175,6 +213,66 @@ func (drv MySQLDriver) DumpSchema(u *url.URL, db *sql.DB) ([]byte, error) {
 	return trimLeadingSQLComments(schema)
 }
 
+// mysqlTablesQuery lists the tables in the current database
+const mysqlTablesQuery = "select table_name from information_schema.tables " +
+	"where table_schema = database() and table_type = 'BASE TABLE' " +
+	"order by table_name"
+
+// DumpTableSchemas returns the schema of each table in the current database.
+// Routines are not associated with a table, and are therefore not included.
+func (drv MySQLDriver) DumpTableSchemas(u *url.URL, db *sql.DB) (map[string][]byte, error) {
+	tables, err := queryColumn(db, mysqlTablesQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{}
+	for _, arg := range mysqldumpArgs(u) {
+		if arg != "--routines" {
+			args = append(args, arg)
+		}
+	}
+
+	// the migrations table is only listed if it is in the current database
+	migrationsSchema, migrationsTable := splitMigrationsTable(drv.migrationsTable())
+	if migrationsSchema == "" {
+		migrationsSchema = databaseName(u)
+	}
+
+	schemas := map[string][]byte{}
+	for _, table := range tables {
+		schema, err := runCommand("mysqldump", append(args, table)...)
+		if err != nil {
+			return nil, err
+		}
+
+		if table == migrationsTable && migrationsSchema == databaseName(u) {
+			migrations, err := mysqlSchemaMigrationsDump(db, drv.quotedMigrationsTable())
+			if err != nil {
+				return nil, err
+			}
+			schema = append(schema, migrations...)
+		}
+
+		if schemas[table], err = trimLeadingSQLComments(schema); err != nil {
+			return nil, err
+		}
+	}
+
+	return schemas, nil
+}
+
+// IntrospectSchema returns a structured description of the current database schema
+func (drv MySQLDriver) IntrospectSchema(ctx context.Context, u *url.URL, db *sql.DB) (*Schema, error) {
+	return introspectSchema(ctx, db, mysqlTablesQuery,
+		"select column_name, column_type, is_nullable = 'YES' from information_schema.columns "+
+			"where table_schema = database() and table_name = ? "+
+			"order by ordinal_position",
+		"select index_name, non_unique = 0, column_name from information_schema.statistics "+
+			"where table_schema = database() and table_name = ? "+
+			"order by index_name, seq_in_index")
+}
+
 // DatabaseExists determines whether the database exists
 func (drv MySQLDriver) DatabaseExists(u *url.URL) (bool, error) {
 	name := databaseName(u)
@@ -195,9 +293,9 @@ func (drv MySQLDriver) DatabaseExists(u *url.URL) (bool, error) {
 	return exists, err
 }
 
-// CreateMigrationsTable creates the schema_migrations table
+// CreateMigrationsTable creates the migrations table
 func (drv MySQLDriver) CreateMigrationsTable(db *sql.DB) error {
-	_, err := db.Exec("create table if not exists schema_migrations " +
+	_, err := db.Exec("create table if not exists " + drv.quotedMigrationsTable() + " " +
 		"(version varchar(255) primary key)")
 
 	return err
@@ -206,7 +304,7 @@ func (drv MySQLDriver) CreateMigrationsTable(db *sql.DB) error {
 // SelectMigrations returns a list of applied migrations
 // with an optional limit (in descending order)
 func (drv MySQLDriver) SelectMigrations(db *sql.DB, limit int) (map[string]bool, error) {
-	query := "select version from schema_migrations order by version desc"
+	query := "select version from " + drv.quotedMigrationsTable() + " order by version desc"
 	if limit >= 0 {
 		query = fmt.Sprintf("%s limit %d", query, limit)
 	}
@@ -232,26 +330,243 @@ func (drv MySQLDriver) SelectMigrations(db *sql.DB, limit int) (map[string]bool,
 
 // InsertMigration adds a new migration record
 func (drv MySQLDriver) InsertMigration(db Transaction, version string) error {
-	_, err := db.Exec("insert into schema_migrations (version) values (?)", version)
+	_, err := db.Exec("insert into "+drv.quotedMigrationsTable()+" (version) values (?)", version)
+
+	return err
+}
+
+// InsertMigrations adds multiple migration records using batched inserts
+func (drv MySQLDriver) InsertMigrations(db Transaction, versions []string) error {
+	return insertMigrationBatches(db, "insert into "+drv.quotedMigrationsTable()+" (version) values", func(i int) string {
+		return "?"
+	}, versions)
+}
+
+// CreateChecksumColumn adds the checksum column to the migrations table, and widens
+// columns created by earlier versions to fit checksums longer than SHA-256
+func (drv MySQLDriver) CreateChecksumColumn(db *sql.DB) error {
+	schema, table := splitMigrationsTable(drv.migrationsTable())
+	var length sql.NullInt64
+	err := db.QueryRow("select character_maximum_length from information_schema.columns "+
+		"where "+mysqlMigrationsTableCondition+" "+
+		"and column_name = 'checksum'", schema, table).Scan(&length)
+	if err == sql.ErrNoRows {
+		_, err = db.Exec("alter table " + drv.quotedMigrationsTable() + " add column checksum varchar(255)")
+		return err
+	}
+	if err != nil || !length.Valid || length.Int64 >= 255 {
+		return err
+	}
+
+	_, err = db.Exec("alter table " + drv.quotedMigrationsTable() + " modify column checksum varchar(255)")
+
+	return err
+}
+
+// UpdateMigrationChecksum records the checksum of an applied migration
+func (drv MySQLDriver) UpdateMigrationChecksum(db Transaction, version, checksum string) error {
+	_, err := db.Exec("update "+drv.quotedMigrationsTable()+" set checksum = ? where version = ?", checksum, version)
+
+	return err
+}
+
+// SelectMigrationChecksums returns the checksums of applied migrations
+func (drv MySQLDriver) SelectMigrationChecksums(db *sql.DB) (map[string]string, error) {
+	return selectMigrationChecksums(db,
+		"select version, checksum from "+drv.quotedMigrationsTable()+" where checksum is not null")
+}
+
+// CreateBatchColumn adds the batch column to the migrations table
+func (drv MySQLDriver) CreateBatchColumn(db *sql.DB) error {
+	schema, table := splitMigrationsTable(drv.migrationsTable())
+	exists := 0
+	err := db.QueryRow("select count(*) from information_schema.columns "+
+		"where "+mysqlMigrationsTableCondition+" "+
+		"and column_name = 'batch'", schema, table).Scan(&exists)
+	if err != nil || exists > 0 {
+		return err
+	}
+
+	_, err = db.Exec("alter table " + drv.quotedMigrationsTable() + " add column batch varchar(255)")
+
+	return err
+}
+
+// UpdateMigrationBatch records the batch of an applied migration
+func (drv MySQLDriver) UpdateMigrationBatch(db Transaction, version, batch string) error {
+	_, err := db.Exec("update "+drv.quotedMigrationsTable()+" set batch = ? where version = ?", batch, version)
+
+	return err
+}
+
+// SelectBatchMigrations returns the versions of the migrations applied in a batch
+func (drv MySQLDriver) SelectBatchMigrations(db *sql.DB, batch string) ([]string, error) {
+	return queryColumn(db, "select version from "+drv.quotedMigrationsTable()+" where batch = ?", batch)
+}
+
+// CheckPermissions verifies that tables and indexes can be created, and migrations
+// recorded. Since MySQL commits schema changes immediately, the privileges to create
+// tables and indexes are looked up rather than probed. Recording a migration is probed
+// in a transaction which is rolled back.
+func (drv MySQLDriver) CheckPermissions(ctx context.Context, db *sql.DB) ([]string, error) {
+	missing := []string{}
+	for _, p := range []struct{ privilege, grant string }{
+		{privilegeCreateTable, "CREATE"},
+		{privilegeCreateIndex, "INDEX"},
+	} {
+		granted := 0
+		err := db.QueryRowContext(ctx, "select count(*) from ("+
+			"select grantee, privilege_type from information_schema.user_privileges "+
+			"union all select grantee, privilege_type from information_schema.schema_privileges "+
+			"where table_schema = database()) p "+
+			"where privilege_type = ? and grantee = concat('''', "+
+			"substring_index(current_user(), '@', 1), '''@''', "+
+			"substring_index(current_user(), '@', -1), '''')", p.grant).Scan(&granted)
+		if err != nil {
+			return nil, err
+		}
+		if granted == 0 {
+			missing = append(missing, p.privilege)
+		}
+	}
+
+	exists := 0
+	schema, table := splitMigrationsTable(drv.migrationsTable())
+	err := db.QueryRowContext(ctx, "select count(*) from information_schema.tables "+
+		"where "+mysqlMigrationsTableCondition, schema, table).Scan(&exists)
+	if err != nil || exists == 0 {
+		return missing, err
+	}
+
+	probed, err := runPermissionProbes(ctx, db, []permissionProbe{{
+		privilege: migrationsTablePrivilege(drv.migrationsTable()),
+		statements: []string{"insert into " + drv.quotedMigrationsTable() + " (version) " +
+			"values ('" + permissionProbeVersion + "')"},
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	return append(missing, probed...), nil
+}
+
+// CreateTimingColumns adds the applied_at and duration_ms columns to the migrations table
+func (drv MySQLDriver) CreateTimingColumns(db *sql.DB) error {
+	schema, table := splitMigrationsTable(drv.migrationsTable())
+	exists := 0
+	err := db.QueryRow("select count(*) from information_schema.columns "+
+		"where "+mysqlMigrationsTableCondition+" "+
+		"and column_name = 'applied_at'", schema, table).Scan(&exists)
+	if err != nil || exists > 0 {
+		return err
+	}
+
+	_, err = db.Exec("alter table " + drv.quotedMigrationsTable() + " add column applied_at datetime(6)")
+	if err == nil {
+		_, err = db.Exec("alter table " + drv.quotedMigrationsTable() + " add column duration_ms bigint")
+	}
+
+	return err
+}
+
+// UpdateMigrationTiming records when a migration was applied, and how long it took
+func (drv MySQLDriver) UpdateMigrationTiming(db Transaction, version string, appliedAt time.Time,
+	duration time.Duration) error {
+	_, err := db.Exec("update "+drv.quotedMigrationsTable()+" set applied_at = ?, duration_ms = ? where version = ?",
+		appliedAt.UTC(), duration.Milliseconds(), version)
+
+	return err
+}
+
+// SelectMigrationTimings returns the timings of applied migrations
+func (drv MySQLDriver) SelectMigrationTimings(ctx context.Context, db *sql.DB) ([]TimingRecord, error) {
+	return selectMigrationTimings(ctx, db,
+		"select version, applied_at, duration_ms from "+drv.quotedMigrationsTable())
+}
+
+// CreateAuditTable creates the audit table, if it does not already exist
+func (drv MySQLDriver) CreateAuditTable(db *sql.DB, table string) error {
+	_, err := db.Exec(fmt.Sprintf("create table if not exists %s (id bigint auto_increment primary key, "+
+		"version varchar(255) not null, direction varchar(4) not null, "+
+		"success boolean not null, created_at datetime(6) not null)", table))
+
+	return err
+}
+
+// InsertAuditRecord appends a record to the audit table
+func (drv MySQLDriver) InsertAuditRecord(db Transaction, table string, record AuditRecord) error {
+	_, err := db.Exec(fmt.Sprintf("insert into %s (version, direction, success, created_at) "+
+		"values (?, ?, ?, ?)", table), record.Version, record.Direction, record.Success, record.Time)
 
 	return err
 }
 
 // DeleteMigration removes a migration record
 func (drv MySQLDriver) DeleteMigration(db Transaction, version string) error {
-	_, err := db.Exec("delete from schema_migrations where version = ?", version)
+	_, err := db.Exec("delete from "+drv.quotedMigrationsTable()+" where version = ?", version)
 
 	return err
 }
 
+// Capabilities returns the features supported by the driver
+func (drv MySQLDriver) Capabilities() DriverCapabilities {
+	return DriverCapabilities{
+		TransactionalDDL:   false,
+		MultiStatementExec: true,
+		ServerAccess:       true,
+	}
+}
+
 // Ping verifies a connection to the database server. It does not verify whether the
 // specified database exists.
 func (drv MySQLDriver) Ping(u *url.URL) error {
+	return drv.PingContext(context.Background(), u)
+}
+
+// PingContext is like Ping, but gives up as soon as ctx is done
+func (drv MySQLDriver) PingContext(ctx context.Context, u *url.URL) error {
 	db, err := drv.openRootDB(u)
 	if err != nil {
 		return err
 	}
 	defer mustClose(db)
 
-	return db.Ping()
+	return db.PingContext(ctx)
+}
+
+// mysqlLockName is the name of the lock of the migrations table. Named locks are
+// scoped to the server, so the name is derived from the database, and hashed to stay
+// within the 64 character limit of lock names.
+const mysqlLockName = "concat('dbmate:', sha1(concat(database(), '.', ?)))"
+
+// AcquireLock waits for the named lock of the migrations table, until the deadline of
+// ctx if it has one
+func (drv MySQLDriver) AcquireLock(ctx context.Context, conn *sql.Conn) error {
+	// a negative timeout waits indefinitely
+	timeout := -1
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = int(math.Ceil(time.Until(deadline).Seconds()))
+		if timeout < 0 {
+			timeout = 0
+		}
+	}
+
+	var acquired sql.NullInt64
+	err := conn.QueryRowContext(ctx, "select get_lock("+mysqlLockName+", ?)",
+		drv.migrationsTable(), timeout).Scan(&acquired)
+	if err != nil {
+		return err
+	}
+	if acquired.Int64 != 1 {
+		return context.DeadlineExceeded
+	}
+
+	return nil
+}
+
+// ReleaseLock releases the named lock of the migrations table
+func (drv MySQLDriver) ReleaseLock(conn *sql.Conn) error {
+	_, err := conn.ExecContext(context.Background(), "select release_lock("+mysqlLockName+")",
+		drv.migrationsTable())
+	return err
 }
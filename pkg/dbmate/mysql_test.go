@@ -1,9 +1,12 @@
 package dbmate
 
 import (
+	"context"
 	"database/sql"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -244,6 +247,123 @@ func TestMySQLInsertMigration(t *testing.T) {
 	require.Equal(t, 1, count)
 }
 
+func TestMySQLInsertMigrations(t *testing.T) {
+	drv := MySQLDriver{}
+	db := prepTestMySQLDB(t)
+	defer mustClose(db)
+
+	err := drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+
+	err = drv.InsertMigrations(db, []string{"abc1", "abc2", "abc3"})
+	require.NoError(t, err)
+
+	count := 0
+	err = db.QueryRow("select count(*) from schema_migrations").Scan(&count)
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+}
+
+func TestMySQLMigrationChecksums(t *testing.T) {
+	drv := MySQLDriver{}
+	db := prepTestMySQLDB(t)
+	defer mustClose(db)
+
+	err := drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+
+	// creating the column twice is allowed
+	err = drv.CreateChecksumColumn(db)
+	require.NoError(t, err)
+	err = drv.CreateChecksumColumn(db)
+	require.NoError(t, err)
+
+	err = drv.InsertMigrations(db, []string{"abc1", "abc2"})
+	require.NoError(t, err)
+	err = drv.UpdateMigrationChecksum(db, "abc1", "123abc")
+	require.NoError(t, err)
+
+	checksums, err := drv.SelectMigrationChecksums(db)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"abc1": "123abc"}, checksums)
+}
+
+func TestMySQLMigrationBatches(t *testing.T) {
+	drv := MySQLDriver{}
+	db := prepTestMySQLDB(t)
+	defer mustClose(db)
+
+	err := drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+
+	// creating the column twice is allowed
+	err = drv.CreateBatchColumn(db)
+	require.NoError(t, err)
+	err = drv.CreateBatchColumn(db)
+	require.NoError(t, err)
+
+	err = drv.InsertMigrations(db, []string{"abc1", "abc2", "abc3"})
+	require.NoError(t, err)
+	err = drv.UpdateMigrationBatch(db, "abc1", "batch1")
+	require.NoError(t, err)
+	err = drv.UpdateMigrationBatch(db, "abc2", "batch1")
+	require.NoError(t, err)
+
+	versions, err := drv.SelectBatchMigrations(db, "batch1")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"abc1", "abc2"}, versions)
+
+	versions, err = drv.SelectBatchMigrations(db, "batch2")
+	require.NoError(t, err)
+	require.Empty(t, versions)
+}
+
+func TestMySQLMigrationsTableSchema(t *testing.T) {
+	require.Equal(t, "`schema_migrations`", MySQLDriver{}.quotedMigrationsTable())
+	drv := MySQLDriver{}.WithMigrationsTable("dbmate_meta.migrations").(MySQLDriver)
+	require.Equal(t, "`dbmate_meta`.`migrations`", drv.quotedMigrationsTable())
+
+	// the schema of the migrations table is another database
+	db := prepTestMySQLDB(t)
+	defer mustClose(db)
+	_, err := db.Exec("drop database if exists dbmate_meta")
+	require.NoError(t, err)
+	_, err = db.Exec("create database dbmate_meta")
+	require.NoError(t, err)
+
+	// creating the table and columns twice is allowed
+	for i := 0; i < 2; i++ {
+		err = drv.CreateMigrationsTable(db)
+		require.NoError(t, err)
+		err = drv.CreateChecksumColumn(db)
+		require.NoError(t, err)
+		err = drv.CreateBatchColumn(db)
+		require.NoError(t, err)
+		err = drv.CreateTimingColumns(db)
+		require.NoError(t, err)
+	}
+
+	err = drv.InsertMigrations(db, []string{"abc1", "abc2"})
+	require.NoError(t, err)
+	err = drv.DeleteMigration(db, "abc2")
+	require.NoError(t, err)
+
+	migrations, err := drv.SelectMigrations(db, -1)
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"abc1": true}, migrations)
+
+	count := 0
+	err = db.QueryRow("select count(*) from dbmate_meta.migrations").Scan(&count)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	// nothing is created in the current database
+	err = db.QueryRow("select count(*) from information_schema.tables " +
+		"where table_schema = database()").Scan(&count)
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+}
+
 func TestMySQLDeleteMigration(t *testing.T) {
 	drv := MySQLDriver{}
 	db := prepTestMySQLDB(t)
@@ -283,3 +403,40 @@ func TestMySQLPing(t *testing.T) {
 	require.Error(t, err)
 	require.Contains(t, err.Error(), "connect: connection refused")
 }
+
+func TestMySQLAdvisoryLock(t *testing.T) {
+	drv := MySQLDriver{}
+	db := prepTestMySQLDB(t)
+	defer mustClose(db)
+
+	ctx := context.Background()
+	conn1, err := db.Conn(ctx)
+	require.NoError(t, err)
+	defer mustClose(conn1)
+	conn2, err := db.Conn(ctx)
+	require.NoError(t, err)
+	defer mustClose(conn2)
+
+	err = drv.AcquireLock(ctx, conn1)
+	require.NoError(t, err)
+
+	// another session waits until the deadline
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	err = drv.AcquireLock(timeoutCtx, conn2)
+	require.Error(t, err)
+
+	err = drv.ReleaseLock(conn1)
+	require.NoError(t, err)
+	err = drv.AcquireLock(ctx, conn2)
+	require.NoError(t, err)
+	err = drv.ReleaseLock(conn2)
+	require.NoError(t, err)
+
+	// long table names fit in the lock name
+	longDrv := MySQLDriver{tableName: strings.Repeat("t", 64)}
+	err = longDrv.AcquireLock(ctx, conn1)
+	require.NoError(t, err)
+	err = longDrv.ReleaseLock(conn1)
+	require.NoError(t, err)
+}
@@ -0,0 +1,112 @@
+package dbmate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Privileges verified by CheckPermissions
+const (
+	privilegeCreateTable = "create table"
+	privilegeCreateIndex = "create index"
+)
+
+// permissionProbeTable is the table created by permission probes, which are rolled back
+const permissionProbeTable = "dbmate_permission_probe"
+
+// permissionProbeVersion is the version inserted in the migrations table by permission
+// probes, which are rolled back
+const permissionProbeVersion = "0_dbmate_permission_probe"
+
+// CheckPermissions verifies, before any migration is applied, that the connecting role
+// has the privileges needed to apply migrations: creating tables and indexes, and
+// recording migrations in the migrations table. It returns the privileges which are
+// missing, if any. The probes run in a transaction which is rolled back, so the
+// database is not modified.
+func (db *DB) CheckPermissions() ([]string, error) {
+	return db.CheckPermissionsContext(context.Background())
+}
+
+// CheckPermissionsContext is like CheckPermissions, but gives up as soon as ctx is done
+func (db *DB) CheckPermissionsContext(ctx context.Context) ([]string, error) {
+	drv, err := db.GetDriver()
+	if err != nil {
+		return nil, err
+	}
+
+	checker, ok := drv.(PermissionChecker)
+	if !ok {
+		return nil, fmt.Errorf("permission checks are not supported by driver: %s",
+			db.DatabaseURL.Scheme)
+	}
+
+	_, sqlDB, err := db.connectDatabase(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer mustClose(sqlDB)
+
+	return checker.CheckPermissions(ctx, sqlDB)
+}
+
+// permissionProbe is a privilege, and the statements which fail without it
+type permissionProbe struct {
+	privilege  string
+	statements []string
+	// dependent probes are skipped, rather than reported as missing, when the
+	// previous probe failed (e.g. an index cannot be created without its table)
+	dependent bool
+}
+
+// runPermissionProbes executes probes in a transaction which is rolled back, each
+// behind a savepoint, and returns the privileges of the probes which failed
+func runPermissionProbes(ctx context.Context, db *sql.DB, probes []permissionProbe) (missing []string, err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); err == nil {
+			err = rollbackErr
+		}
+	}()
+
+	missing = []string{}
+	failed := false
+	for i, probe := range probes {
+		if probe.dependent && failed {
+			continue
+		}
+
+		savepoint := fmt.Sprintf("dbmate_probe_%d", i)
+		if _, err := tx.ExecContext(ctx, "savepoint " + savepoint); err != nil {
+			return nil, err
+		}
+
+		failed = false
+		for _, stmt := range probe.statements {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				failed = true
+				break
+			}
+		}
+
+		if failed {
+			missing = append(missing, probe.privilege)
+			_, err = tx.ExecContext(ctx, "rollback to savepoint " + savepoint)
+		} else {
+			_, err = tx.ExecContext(ctx, "release savepoint " + savepoint)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return missing, nil
+}
+
+// migrationsTablePrivilege is the privilege probed by inserting in the migrations table
+func migrationsTablePrivilege(table string) string {
+	return fmt.Sprintf("insert into %s", table)
+}
@@ -0,0 +1,65 @@
+package dbmate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPermissions(t *testing.T) {
+	u := sqliteTestURL(t)
+	db := newTestDB(t, u)
+	db.AutoDumpSchema = false
+
+	err := db.Drop()
+	require.NoError(t, err)
+	err = db.Create()
+	require.NoError(t, err)
+
+	// without a migrations table
+	missing, err := db.CheckPermissions()
+	require.NoError(t, err)
+	require.Empty(t, missing)
+
+	err = db.Migrate()
+	require.NoError(t, err)
+	missing, err = db.CheckPermissions()
+	require.NoError(t, err)
+	require.Empty(t, missing)
+
+	// the probes were rolled back
+	sqlDB, err := GetDriverOpen(u)
+	require.NoError(t, err)
+	defer mustClose(sqlDB)
+
+	count := 0
+	err = sqlDB.QueryRow("select count(*) from sqlite_master where name like 'dbmate_permission_probe%'").
+		Scan(&count)
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+
+	results, err := db.StatusResults()
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+}
+
+func TestRunPermissionProbes(t *testing.T) {
+	sqlDB := prepTestSQLiteDB(t)
+	defer mustClose(sqlDB)
+
+	missing, err := runPermissionProbes(context.Background(), sqlDB, []permissionProbe{
+		{privilege: "create table", statements: []string{"create table probe (id integer)"}},
+		{privilege: "insert", statements: []string{"insert into missing_table values (1)"}},
+		{privilege: "create index", statements: []string{"create index probe_idx on missing_table (id)"},
+			dependent: true},
+		{privilege: "update", statements: []string{"update probe set id = 1"}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"insert"}, missing)
+
+	count := 0
+	err = sqlDB.QueryRow("select count(*) from sqlite_master where name = 'probe'").Scan(&count)
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+}
@@ -2,10 +2,14 @@ package dbmate
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"fmt"
+	"hash/fnv"
 	"net/url"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/lib/pq"
 )
@@ -17,11 +21,65 @@ func init() {
 
 // PostgresDriver provides top level database functions
 type PostgresDriver struct {
+	tableName string
+}
+
+// WithMigrationsTable returns a copy of the driver recording migrations in the named
+// table, in the public schema unless the name is qualified with a schema
+func (drv PostgresDriver) WithMigrationsTable(name string) Driver {
+	drv.tableName = name
+	return drv
+}
+
+// migrationsTable returns the name of the migrations table, as configured
+func (drv PostgresDriver) migrationsTable() string {
+	if drv.tableName == "" {
+		return migrationsTableName
+	}
+
+	return drv.tableName
+}
+
+// migrationsSchemaAndTable returns the schema of the migrations table, public unless
+// the name is qualified, and its unqualified name
+func (drv PostgresDriver) migrationsSchemaAndTable() (string, string) {
+	schema, table := splitMigrationsTable(drv.migrationsTable())
+	if schema == "" {
+		schema = "public"
+	}
+
+	return schema, table
+}
+
+// quotedMigrationsTable returns the qualified name of the migrations table, quoted for
+// use in statements
+func (drv PostgresDriver) quotedMigrationsTable() string {
+	schema, table := drv.migrationsSchemaAndTable()
+	return postgresQuoteIdentifier(schema) + "." + postgresQuoteIdentifier(table)
+}
+
+// postgresPlainIdentifierRegExp matches the identifiers which PostgreSQL does not fold
+// to another name when they are unquoted
+var postgresPlainIdentifierRegExp = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// postgresQuoteIdentifier quotes an identifier unless it is lowercase, like pg_dump,
+// so that the schema dumps of existing databases are unchanged
+func postgresQuoteIdentifier(str string) string {
+	if postgresPlainIdentifierRegExp.MatchString(str) {
+		return str
+	}
+
+	return pq.QuoteIdentifier(str)
 }
 
 // Open creates a new database connection
 func (drv PostgresDriver) Open(u *url.URL) (*sql.DB, error) {
-	return sql.Open("postgres", u.String())
+	return sql.Open(drv.dataSourceName(u))
+}
+
+// dataSourceName returns the database/sql driver name and data source name
+func (drv PostgresDriver) dataSourceName(u *url.URL) (string, string) {
+	return "postgres", u.String()
 }
 
 func (drv PostgresDriver) openPostgresDB(u *url.URL) (*sql.DB, error) {
@@ -35,7 +93,6 @@ func (drv PostgresDriver) openPostgresDB(u *url.URL) (*sql.DB, error) {
 // CreateDatabase creates the specified database
 func (drv PostgresDriver) CreateDatabase(u *url.URL) error {
 	name := databaseName(u)
-	fmt.Printf("Creating: %s\n", name)
 
 	db, err := drv.openPostgresDB(u)
 	if err != nil {
@@ -52,7 +109,6 @@ func (drv PostgresDriver) CreateDatabase(u *url.URL) error {
 // DropDatabase drops the specified database (if it exists)
 func (drv PostgresDriver) DropDatabase(u *url.URL) error {
 	name := databaseName(u)
-	fmt.Printf("Dropping: %s\n", name)
 
 	db, err := drv.openPostgresDB(u)
 	if err != nil {
@@ -66,20 +122,20 @@ func (drv PostgresDriver) DropDatabase(u *url.URL) error {
 	return err
 }
 
-func postgresSchemaMigrationsDump(db *sql.DB) ([]byte, error) {
+func postgresSchemaMigrationsDump(db *sql.DB, table string) ([]byte, error) {
 	// load applied migrations
 	migrations, err := queryColumn(db,
-		"select quote_literal(version) from public.schema_migrations order by version asc")
+		"select quote_literal(version) from "+table+" order by version asc")
 	if err != nil {
 		return nil, err
 	}
 
-	// build schema_migrations table data
+	// build migrations table data
 	var buf bytes.Buffer
 	buf.WriteString("\n--\n-- Dbmate schema migrations\n--\n\n")
 
 	if len(migrations) > 0 {
-		buf.WriteString("INSERT INTO public.schema_migrations (version) VALUES\n    (" +
+		buf.WriteString("INSERT INTO " + table + " (version) VALUES\n    (" +
 			strings.Join(migrations, "),\n    (") +
 			");\n")
 	}
@@ -96,7 +152,7 @@ func (drv PostgresDriver) DumpSchema(u *url.URL, db *sql.DB) ([]byte, error) {
 		return nil, err
 	}
 
-	migrations, err := postgresSchemaMigrationsDump(db)
+	migrations, err := postgresSchemaMigrationsDump(db, drv.quotedMigrationsTable())
 	if err != nil {
 		return nil, err
 	}
@@ -105,6 +161,64 @@ func (drv PostgresDriver) DumpSchema(u *url.URL, db *sql.DB) ([]byte, error) {
 	return trimLeadingSQLComments(schema)
 }
 
+// postgresTablesQuery lists the tables in the current schema
+const postgresTablesQuery = "select table_name from information_schema.tables " +
+	"where table_schema = current_schema() and table_type = 'BASE TABLE' " +
+	"order by table_name"
+
+// DumpTableSchemas returns the schema of each table in the current schema
+func (drv PostgresDriver) DumpTableSchemas(u *url.URL, db *sql.DB) (map[string][]byte, error) {
+	tables, err := queryColumn(db, postgresTablesQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	// the migrations table is only listed if it is in the current schema
+	migrationsSchema, migrationsTable := drv.migrationsSchemaAndTable()
+	currentSchema := ""
+	if err := db.QueryRow("select current_schema()").Scan(&currentSchema); err != nil {
+		return nil, err
+	}
+
+	schemas := map[string][]byte{}
+	for _, table := range tables {
+		schema, err := runCommand("pg_dump", "--format=plain", "--encoding=UTF8",
+			"--schema-only", "--no-privileges", "--no-owner",
+			"--table="+pq.QuoteIdentifier(table), u.String())
+		if err != nil {
+			return nil, err
+		}
+
+		if table == migrationsTable && currentSchema == migrationsSchema {
+			migrations, err := postgresSchemaMigrationsDump(db, drv.quotedMigrationsTable())
+			if err != nil {
+				return nil, err
+			}
+			schema = append(schema, migrations...)
+		}
+
+		if schemas[table], err = trimLeadingSQLComments(schema); err != nil {
+			return nil, err
+		}
+	}
+
+	return schemas, nil
+}
+
+// IntrospectSchema returns a structured description of the tables in the current schema
+func (drv PostgresDriver) IntrospectSchema(ctx context.Context, u *url.URL, db *sql.DB) (*Schema, error) {
+	return introspectSchema(ctx, db, postgresTablesQuery,
+		"select column_name, data_type, is_nullable = 'YES' from information_schema.columns "+
+			"where table_schema = current_schema() and table_name = $1 "+
+			"order by ordinal_position",
+		"select i.relname, ix.indisunique, a.attname from pg_index ix "+
+			"join pg_class t on t.oid = ix.indrelid "+
+			"join pg_class i on i.oid = ix.indexrelid "+
+			"join pg_attribute a on a.attrelid = t.oid and a.attnum = any(ix.indkey) "+
+			"where t.relnamespace = current_schema()::regnamespace and t.relname = $1 "+
+			"order by i.relname, array_position(ix.indkey::int2[], a.attnum)")
+}
+
 // DatabaseExists determines whether the database exists
 func (drv PostgresDriver) DatabaseExists(u *url.URL) (bool, error) {
 	name := databaseName(u)
@@ -125,9 +239,9 @@ func (drv PostgresDriver) DatabaseExists(u *url.URL) (bool, error) {
 	return exists, err
 }
 
-// CreateMigrationsTable creates the schema_migrations table
+// CreateMigrationsTable creates the migrations table
 func (drv PostgresDriver) CreateMigrationsTable(db *sql.DB) error {
-	_, err := db.Exec("create table if not exists public.schema_migrations " +
+	_, err := db.Exec("create table if not exists " + drv.quotedMigrationsTable() + " " +
 		"(version varchar(255) primary key)")
 
 	return err
@@ -136,7 +250,7 @@ func (drv PostgresDriver) CreateMigrationsTable(db *sql.DB) error {
 // SelectMigrations returns a list of applied migrations
 // with an optional limit (in descending order)
 func (drv PostgresDriver) SelectMigrations(db *sql.DB, limit int) (map[string]bool, error) {
-	query := "select version from public.schema_migrations order by version desc"
+	query := "select version from " + drv.quotedMigrationsTable() + " order by version desc"
 	if limit >= 0 {
 		query = fmt.Sprintf("%s limit %d", query, limit)
 	}
@@ -162,21 +276,271 @@ func (drv PostgresDriver) SelectMigrations(db *sql.DB, limit int) (map[string]bo
 
 // InsertMigration adds a new migration record
 func (drv PostgresDriver) InsertMigration(db Transaction, version string) error {
-	_, err := db.Exec("insert into public.schema_migrations (version) values ($1)", version)
+	_, err := db.Exec("insert into "+drv.quotedMigrationsTable()+" (version) values ($1)", version)
+
+	return err
+}
+
+// InsertMigrations adds multiple migration records using batched inserts
+func (drv PostgresDriver) InsertMigrations(db Transaction, versions []string) error {
+	return insertMigrationBatches(db, "insert into "+drv.quotedMigrationsTable()+" (version) values", func(i int) string {
+		return fmt.Sprintf("$%d", i+1)
+	}, versions)
+}
+
+// CreateChecksumColumn adds the checksum column to the migrations table, and widens
+// columns created by earlier versions to fit checksums longer than SHA-256
+func (drv PostgresDriver) CreateChecksumColumn(db *sql.DB) error {
+	_, err := db.Exec("alter table " + drv.quotedMigrationsTable() + " " +
+		"add column if not exists checksum varchar(255)")
+	if err != nil {
+		return err
+	}
+
+	schema, table := drv.migrationsSchemaAndTable()
+	var length sql.NullInt64
+	err = db.QueryRow("select character_maximum_length from information_schema.columns "+
+		"where table_schema = $1 and table_name = $2 "+
+		"and column_name = 'checksum'", schema, table).Scan(&length)
+	if err != nil || !length.Valid || length.Int64 >= 255 {
+		return err
+	}
+
+	_, err = db.Exec("alter table " + drv.quotedMigrationsTable() + " " +
+		"alter column checksum type varchar(255)")
 
 	return err
 }
 
+// UpdateMigrationChecksum records the checksum of an applied migration
+func (drv PostgresDriver) UpdateMigrationChecksum(db Transaction, version, checksum string) error {
+	_, err := db.Exec("update "+drv.quotedMigrationsTable()+" set checksum = $1 where version = $2", checksum, version)
+
+	return err
+}
+
+// SelectMigrationChecksums returns the checksums of applied migrations
+func (drv PostgresDriver) SelectMigrationChecksums(db *sql.DB) (map[string]string, error) {
+	return selectMigrationChecksums(db,
+		"select version, checksum from "+drv.quotedMigrationsTable()+" where checksum is not null")
+}
+
+// CreateBatchColumn adds the batch column to the migrations table
+func (drv PostgresDriver) CreateBatchColumn(db *sql.DB) error {
+	_, err := db.Exec("alter table " + drv.quotedMigrationsTable() + " " +
+		"add column if not exists batch varchar(255)")
+
+	return err
+}
+
+// UpdateMigrationBatch records the batch of an applied migration
+func (drv PostgresDriver) UpdateMigrationBatch(db Transaction, version, batch string) error {
+	_, err := db.Exec("update "+drv.quotedMigrationsTable()+" set batch = $1 where version = $2", batch, version)
+
+	return err
+}
+
+// SelectBatchMigrations returns the versions of the migrations applied in a batch
+func (drv PostgresDriver) SelectBatchMigrations(db *sql.DB, batch string) ([]string, error) {
+	return queryColumn(db, "select version from "+drv.quotedMigrationsTable()+" where batch = $1", batch)
+}
+
+// CheckPermissions verifies that tables and indexes can be created, and migrations
+// recorded, in a transaction which is rolled back
+func (drv PostgresDriver) CheckPermissions(ctx context.Context, db *sql.DB) ([]string, error) {
+	probes := []permissionProbe{
+		{
+			privilege:  privilegeCreateTable,
+			statements: []string{"create table " + permissionProbeTable + " (id integer)"},
+		},
+		{
+			privilege:  privilegeCreateIndex,
+			statements: []string{"create index on " + permissionProbeTable + " (id)"},
+			dependent:  true,
+		},
+	}
+
+	exists := false
+	err := db.QueryRowContext(ctx, "select to_regclass($1) is not null",
+		drv.quotedMigrationsTable()).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		probes = append(probes, permissionProbe{
+			privilege: migrationsTablePrivilege(drv.migrationsTable()),
+			statements: []string{"insert into " + drv.quotedMigrationsTable() + " (version) " +
+				"values ('" + permissionProbeVersion + "')"},
+		})
+	}
+
+	return runPermissionProbes(ctx, db, probes)
+}
+
+// CreateTimingColumns adds the applied_at and duration_ms columns to the migrations table
+func (drv PostgresDriver) CreateTimingColumns(db *sql.DB) error {
+	_, err := db.Exec("alter table " + drv.quotedMigrationsTable() + " " +
+		"add column if not exists applied_at timestamp, " +
+		"add column if not exists duration_ms bigint")
+
+	return err
+}
+
+// UpdateMigrationTiming records when a migration was applied, and how long it took
+func (drv PostgresDriver) UpdateMigrationTiming(db Transaction, version string, appliedAt time.Time,
+	duration time.Duration) error {
+	_, err := db.Exec("update "+drv.quotedMigrationsTable()+" set applied_at = $1, duration_ms = $2 "+
+		"where version = $3", appliedAt.UTC(), duration.Milliseconds(), version)
+
+	return err
+}
+
+// SelectMigrationTimings returns the timings of applied migrations
+func (drv PostgresDriver) SelectMigrationTimings(ctx context.Context, db *sql.DB) ([]TimingRecord, error) {
+	return selectMigrationTimings(ctx, db,
+		"select version, applied_at, duration_ms from "+drv.quotedMigrationsTable())
+}
+
+// CreateAuditTable creates the audit table, if it does not already exist
+func (drv PostgresDriver) CreateAuditTable(db *sql.DB, table string) error {
+	_, err := db.Exec(fmt.Sprintf("create table if not exists %s (id bigserial primary key, "+
+		"version varchar(255) not null, direction varchar(4) not null, "+
+		"success boolean not null, created_at timestamp not null)", table))
+
+	return err
+}
+
+// InsertAuditRecord appends a record to the audit table
+func (drv PostgresDriver) InsertAuditRecord(db Transaction, table string, record AuditRecord) error {
+	_, err := db.Exec(fmt.Sprintf("insert into %s (version, direction, success, created_at) "+
+		"values ($1, $2, $3, $4)", table), record.Version, record.Direction, record.Success, record.Time)
+
+	return err
+}
+
+// CheckForeignKeys validates the foreign key constraints which were created (or left)
+// unvalidated with NOT VALID, which PostgreSQL does not check against existing rows.
+// Each constraint is validated in a transaction which is then rolled back, so that
+// the database is not modified.
+func (drv PostgresDriver) CheckForeignKeys(db *sql.DB) ([]string, error) {
+	rows, err := db.Query("select conrelid::regclass::text, conname from pg_catalog.pg_constraint " +
+		"where contype = 'f' and not convalidated order by 1, 2")
+	if err != nil {
+		return nil, err
+	}
+	defer mustClose(rows)
+
+	type constraint struct{ table, name string }
+	constraints := []constraint{}
+	for rows.Next() {
+		var c constraint
+		if err := rows.Scan(&c.table, &c.name); err != nil {
+			return nil, err
+		}
+		constraints = append(constraints, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	violations := []string{}
+	for _, c := range constraints {
+		tx, err := db.Begin()
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.Exec(fmt.Sprintf("alter table %s validate constraint %s",
+			c.table, pq.QuoteIdentifier(c.name)))
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, rollbackErr
+		}
+		if err != nil {
+			violations = append(violations, fmt.Sprintf("`%s` constraint `%s`: %s", c.table, c.name, err))
+		}
+	}
+
+	return violations, nil
+}
+
 // DeleteMigration removes a migration record
 func (drv PostgresDriver) DeleteMigration(db Transaction, version string) error {
-	_, err := db.Exec("delete from public.schema_migrations where version = $1", version)
+	_, err := db.Exec("delete from "+drv.quotedMigrationsTable()+" where version = $1", version)
 
 	return err
 }
 
+// ReplicaLag returns the replay lag of the slowest streaming replica, as reported by
+// the primary server (PostgreSQL 10+). It is zero if the server has no replicas.
+func (drv PostgresDriver) ReplicaLag(db *sql.DB) (time.Duration, error) {
+	var seconds float64
+	err := db.QueryRow("select coalesce(extract(epoch from max(replay_lag)), 0) " +
+		"from pg_stat_replication").Scan(&seconds)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// advisoryLockKey returns the key of the advisory lock of the migrations table
+func (drv PostgresDriver) advisoryLockKey() int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte("dbmate:" + drv.migrationsTable()))
+	return int64(h.Sum64())
+}
+
+// AcquireLock waits for the session level advisory lock of the migrations table.
+// Advisory locks are scoped to the database.
+func (drv PostgresDriver) AcquireLock(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, "select pg_advisory_lock($1)", drv.advisoryLockKey())
+	return err
+}
+
+// ReleaseLock releases the advisory lock of the migrations table
+func (drv PostgresDriver) ReleaseLock(conn *sql.Conn) error {
+	_, err := conn.ExecContext(context.Background(), "select pg_advisory_unlock($1)",
+		drv.advisoryLockKey())
+	return err
+}
+
+// postgresConcurrentlyRegExp matches the statements which PostgreSQL refuses to run
+// inside a transaction block when given the CONCURRENTLY option
+var postgresConcurrentlyRegExp = regexp.MustCompile(`(?is)^(\s*(--[^\n]*(\n|$)|/\*.*?\*/))*\s*` +
+	`(create\s+(unique\s+)?index|drop\s+index|reindex|refresh\s+materialized\s+view|` +
+	`alter\s+table\s+.*?\s+detach\s+partition)\b.*?\bconcurrently\b`)
+
+// NonTransactionalStatement returns the first statement of script using CONCURRENTLY,
+// e.g. `create index concurrently`, which cannot run inside a transaction. Comments
+// preceding the statement are not returned.
+func (drv PostgresDriver) NonTransactionalStatement(script string) string {
+	for _, statement := range parseStatements(script) {
+		if m := postgresConcurrentlyRegExp.FindStringSubmatchIndex(statement); m != nil {
+			// start of the statement keywords, after any comments
+			return strings.TrimSpace(statement[m[8]:])
+		}
+	}
+
+	return ""
+}
+
+// Capabilities returns the features supported by the driver
+func (drv PostgresDriver) Capabilities() DriverCapabilities {
+	return DriverCapabilities{
+		TransactionalDDL:   true,
+		MultiStatementExec: true,
+		ServerAccess:       true,
+	}
+}
+
 // Ping verifies a connection to the database server. It does not verify whether the
 // specified database exists.
 func (drv PostgresDriver) Ping(u *url.URL) error {
+	return drv.PingContext(context.Background(), u)
+}
+
+// PingContext is like Ping, but gives up as soon as ctx is done
+func (drv PostgresDriver) PingContext(ctx context.Context, u *url.URL) error {
 	// attempt connection to primary database, not "postgres" database
 	// to support servers with no "postgres" database
 	// (see https://github.com/amacneil/dbmate/issues/78)
@@ -186,7 +550,7 @@ func (drv PostgresDriver) Ping(u *url.URL) error {
 	}
 	defer mustClose(db)
 
-	err = db.Ping()
+	err = db.PingContext(ctx)
 	if err == nil {
 		return nil
 	}
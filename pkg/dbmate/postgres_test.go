@@ -1,9 +1,11 @@
 package dbmate
 
 import (
+	"context"
 	"database/sql"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -215,6 +217,147 @@ func TestPostgresInsertMigration(t *testing.T) {
 	require.Equal(t, 1, count)
 }
 
+func TestPostgresInsertMigrations(t *testing.T) {
+	drv := PostgresDriver{}
+	db := prepTestPostgresDB(t)
+	defer mustClose(db)
+
+	err := drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+
+	err = drv.InsertMigrations(db, []string{"abc1", "abc2", "abc3"})
+	require.NoError(t, err)
+
+	count := 0
+	err = db.QueryRow("select count(*) from public.schema_migrations").Scan(&count)
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+}
+
+func TestPostgresMigrationChecksums(t *testing.T) {
+	drv := PostgresDriver{}
+	db := prepTestPostgresDB(t)
+	defer mustClose(db)
+
+	err := drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+
+	// creating the column twice is allowed
+	err = drv.CreateChecksumColumn(db)
+	require.NoError(t, err)
+	err = drv.CreateChecksumColumn(db)
+	require.NoError(t, err)
+
+	err = drv.InsertMigrations(db, []string{"abc1", "abc2"})
+	require.NoError(t, err)
+	err = drv.UpdateMigrationChecksum(db, "abc1", "123abc")
+	require.NoError(t, err)
+
+	checksums, err := drv.SelectMigrationChecksums(db)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"abc1": "123abc"}, checksums)
+}
+
+func TestPostgresMigrationBatches(t *testing.T) {
+	drv := PostgresDriver{}
+	db := prepTestPostgresDB(t)
+	defer mustClose(db)
+
+	err := drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+
+	// creating the column twice is allowed
+	err = drv.CreateBatchColumn(db)
+	require.NoError(t, err)
+	err = drv.CreateBatchColumn(db)
+	require.NoError(t, err)
+
+	err = drv.InsertMigrations(db, []string{"abc1", "abc2", "abc3"})
+	require.NoError(t, err)
+	err = drv.UpdateMigrationBatch(db, "abc1", "batch1")
+	require.NoError(t, err)
+	err = drv.UpdateMigrationBatch(db, "abc2", "batch1")
+	require.NoError(t, err)
+
+	versions, err := drv.SelectBatchMigrations(db, "batch1")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"abc1", "abc2"}, versions)
+
+	versions, err = drv.SelectBatchMigrations(db, "batch2")
+	require.NoError(t, err)
+	require.Empty(t, versions)
+}
+
+func TestPostgresMigrationsTableSchema(t *testing.T) {
+	// lowercase names are left unquoted, like pg_dump does
+	require.Equal(t, "public.schema_migrations", PostgresDriver{}.quotedMigrationsTable())
+	drv := PostgresDriver{}.WithMigrationsTable("App.Migrations").(PostgresDriver)
+	require.Equal(t, `"App"."Migrations"`, drv.quotedMigrationsTable())
+
+	db := prepTestPostgresDB(t)
+	defer mustClose(db)
+	_, err := db.Exec(`create schema "App"`)
+	require.NoError(t, err)
+
+	// creating the table and columns twice is allowed
+	for i := 0; i < 2; i++ {
+		err = drv.CreateMigrationsTable(db)
+		require.NoError(t, err)
+		err = drv.CreateChecksumColumn(db)
+		require.NoError(t, err)
+		err = drv.CreateBatchColumn(db)
+		require.NoError(t, err)
+		err = drv.CreateTimingColumns(db)
+		require.NoError(t, err)
+	}
+
+	err = drv.InsertMigrations(db, []string{"abc1", "abc2"})
+	require.NoError(t, err)
+	err = drv.DeleteMigration(db, "abc2")
+	require.NoError(t, err)
+
+	migrations, err := drv.SelectMigrations(db, -1)
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"abc1": true}, migrations)
+
+	missing, err := drv.CheckPermissions(context.Background(), db)
+	require.NoError(t, err)
+	require.Empty(t, missing)
+
+	// nothing is created in the public schema
+	exists := true
+	err = db.QueryRow("select to_regclass('public.schema_migrations') is not null").Scan(&exists)
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestPostgresCheckForeignKeys(t *testing.T) {
+	drv := PostgresDriver{}
+	db := prepTestPostgresDB(t)
+	defer mustClose(db)
+
+	_, err := db.Exec("create table users (id integer primary key);" +
+		"create table posts (id integer primary key, user_id integer);" +
+		"insert into users (id) values (1);" +
+		"insert into posts (id, user_id) values (1, 1), (2, 2);" +
+		"alter table posts add constraint posts_user_id_fkey " +
+		"foreign key (user_id) references users (id) not valid")
+	require.NoError(t, err)
+
+	violations, err := drv.CheckForeignKeys(db)
+	require.NoError(t, err)
+	require.Len(t, violations, 1)
+	require.Contains(t, violations[0], "`posts` constraint `posts_user_id_fkey`: ")
+	require.Contains(t, violations[0], "violates foreign key constraint")
+
+	// the constraint is not validated by the check
+	_, err = db.Exec("delete from posts where id = 2")
+	require.NoError(t, err)
+	violations, err = drv.CheckForeignKeys(db)
+	require.NoError(t, err)
+	require.Empty(t, violations)
+}
+
 func TestPostgresDeleteMigration(t *testing.T) {
 	drv := PostgresDriver{}
 	db := prepTestPostgresDB(t)
@@ -236,6 +379,36 @@ func TestPostgresDeleteMigration(t *testing.T) {
 	require.Equal(t, 1, count)
 }
 
+func TestPostgresReplicaLag(t *testing.T) {
+	drv := PostgresDriver{}
+	db := prepTestPostgresDB(t)
+	defer mustClose(db)
+
+	// test database has no replicas
+	lag, err := drv.ReplicaLag(db)
+	require.NoError(t, err)
+	require.Equal(t, time.Duration(0), lag)
+}
+
+func TestPostgresNonTransactionalStatement(t *testing.T) {
+	drv := PostgresDriver{}
+
+	cases := map[string]string{
+		"create table users (id int);": "",
+		"create index users_id on users (id);\n" +
+			"create unique index concurrently users_email on users (email);": "create unique index concurrently users_email on users (email)",
+		"-- migrate:up\n/* rebuild */\nDROP INDEX CONCURRENTLY users_id;": "DROP INDEX CONCURRENTLY users_id",
+		"reindex index concurrently users_id;":                            "reindex index concurrently users_id",
+		"refresh materialized view concurrently stats;":                   "refresh materialized view concurrently stats",
+		"alter table logs detach partition logs_2020 concurrently;":       "alter table logs detach partition logs_2020 concurrently",
+		"insert into notes (body) values ('create index concurrently');":  "",
+	}
+
+	for script, expected := range cases {
+		require.Equal(t, expected, drv.NonTransactionalStatement(script), script)
+	}
+}
+
 func TestPostgresPing(t *testing.T) {
 	drv := PostgresDriver{}
 	u := postgresTestURL(t)
@@ -254,3 +427,33 @@ func TestPostgresPing(t *testing.T) {
 	require.Error(t, err)
 	require.Contains(t, err.Error(), "connect: connection refused")
 }
+
+func TestPostgresAdvisoryLock(t *testing.T) {
+	drv := PostgresDriver{}
+	db := prepTestPostgresDB(t)
+	defer mustClose(db)
+
+	ctx := context.Background()
+	conn1, err := db.Conn(ctx)
+	require.NoError(t, err)
+	defer mustClose(conn1)
+	conn2, err := db.Conn(ctx)
+	require.NoError(t, err)
+	defer mustClose(conn2)
+
+	err = drv.AcquireLock(ctx, conn1)
+	require.NoError(t, err)
+
+	// another session waits until the deadline
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	err = drv.AcquireLock(timeoutCtx, conn2)
+	require.Error(t, err)
+
+	err = drv.ReleaseLock(conn1)
+	require.NoError(t, err)
+	err = drv.AcquireLock(ctx, conn2)
+	require.NoError(t, err)
+	err = drv.ReleaseLock(conn2)
+	require.NoError(t, err)
+}
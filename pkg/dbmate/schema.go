@@ -0,0 +1,446 @@
+package dbmate
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Schema is a structured description of a database schema
+type Schema struct {
+	Tables []SchemaTable `json:"tables"`
+}
+
+// SchemaTable describes a table and its columns and indexes
+type SchemaTable struct {
+	Name    string         `json:"name"`
+	Columns []SchemaColumn `json:"columns"`
+	Indexes []SchemaIndex  `json:"indexes"`
+}
+
+// SchemaColumn describes a table column
+type SchemaColumn struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Nullable bool   `json:"nullable"`
+}
+
+// SchemaIndex describes a table index
+type SchemaIndex struct {
+	Name    string   `json:"name"`
+	Unique  bool     `json:"unique"`
+	Columns []string `json:"columns"`
+}
+
+// schema dump formats, selected by the schema file extension
+const (
+	schemaFormatSQL  = "sql"
+	schemaFormatJSON = "json"
+)
+
+// schemaFileFormat returns the dump format for a schema file, and whether the file
+// should be gzip compressed. A trailing `.gz` extension enables compression, and the
+// extension preceding it selects the format (e.g. `schema.json.gz`). Any extension
+// other than `.json` is treated as raw SQL.
+func schemaFileFormat(path string) (string, bool) {
+	ext := strings.ToLower(filepath.Ext(path))
+	compress := ext == ".gz"
+	if compress {
+		ext = strings.ToLower(filepath.Ext(strings.TrimSuffix(path, filepath.Ext(path))))
+	}
+
+	if ext == ".json" {
+		return schemaFormatJSON, compress
+	}
+
+	return schemaFormatSQL, compress
+}
+
+// schemaDriverRegExp matches the driver marker on the first line of SQL schema files
+var schemaDriverRegExp = regexp.MustCompile(`^--\s*dbmate:driver\s+(\S+)\s*$`)
+
+// schemaDriverHeader returns the marker recording which driver dumped a SQL schema
+func schemaDriverHeader(scheme string) []byte {
+	return []byte(fmt.Sprintf("-- dbmate:driver %s\n\n", scheme))
+}
+
+// readSchemaDriver returns the driver recorded in a SQL schema file, or an empty
+// string if the file does not exist or has no driver marker
+func readSchemaDriver(fsys fs.FS, path string, compressed bool) (string, error) {
+	f, err := fsys.Open(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	defer mustClose(f)
+
+	var r io.Reader = f
+	if compressed {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return "", err
+		}
+		defer mustClose(zr)
+		r = zr
+	}
+
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	match := schemaDriverRegExp.FindStringSubmatch(line)
+	if match == nil {
+		return "", nil
+	}
+
+	return match[1], nil
+}
+
+// schemaMigrationsMarker precedes the applied migrations in SQL schema dumps
+const schemaMigrationsMarker = "-- Dbmate schema migrations"
+
+// schemaMigrationsInsert starts the statement recording the applied migrations in SQL
+// schema dumps
+const schemaMigrationsInsert = "INSERT INTO "
+
+// schemaVersionRegExp matches the quoted versions recorded in SQL schema dumps
+var schemaVersionRegExp = regexp.MustCompile(`'([^']+)'`)
+
+// readSchemaVersions returns the migration versions recorded in a SQL schema file.
+// It returns false if the file does not exist, or does not record migrations.
+func readSchemaVersions(fsys fs.FS, path string, compressed bool) (map[string]bool, bool, error) {
+	data, err := readSchemaFile(fsys, path, compressed)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	i := bytes.Index(data, []byte(schemaMigrationsMarker))
+	if i < 0 {
+		return nil, false, nil
+	}
+
+	// the versions are quoted in the insert statement following the marker, which may
+	// be followed by a SchemaEpilogue
+	data = data[i:]
+	if j := bytes.Index(data, []byte(schemaMigrationsInsert)); j >= 0 {
+		data = data[j:]
+		if k := bytes.Index(data, []byte(";\n")); k >= 0 {
+			data = data[:k]
+		}
+	} else {
+		data = nil
+	}
+
+	versions := map[string]bool{}
+	for _, match := range schemaVersionRegExp.FindAllSubmatch(data, -1) {
+		versions[string(match[1])] = true
+	}
+	delete(versions, migrationsLockVersion)
+
+	return versions, true, nil
+}
+
+// readSchemaFile reads a schema file, decompressing it if requested
+func readSchemaFile(fsys fs.FS, path string, compressed bool) ([]byte, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer mustClose(f)
+
+	if !compressed {
+		return ioutil.ReadAll(f)
+	}
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer mustClose(zr)
+
+	return ioutil.ReadAll(zr)
+}
+
+// marshalSchema encodes a structured schema as indented JSON
+func marshalSchema(schema *Schema) ([]byte, error) {
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(data, '\n'), nil
+}
+
+// gzipBytes compresses data using gzip
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeSchemaFile writes schema data to path, compressing it if requested
+func writeSchemaFile(fsys FileSystem, path string, data []byte, compress bool, perm os.FileMode) error {
+	if compress {
+		var err error
+		if data, err = gzipBytes(data); err != nil {
+			return err
+		}
+	}
+
+	return fsys.WriteFile(path, data, perm)
+}
+
+// schemaTableDir returns the directory holding per table schema files, which is
+// named after the schema file without its extension (e.g. `db/schema` for `db/schema.sql.gz`)
+func schemaTableDir(path string, compressed bool) string {
+	if compressed {
+		path = strings.TrimSuffix(path, filepath.Ext(path))
+	}
+
+	return strings.TrimSuffix(path, filepath.Ext(path))
+}
+
+// migrationsSchemaFile returns the schema file which records the applied migrations
+// and the driver: SchemaFile, or the file of the migrations table when the schema
+// is dumped per table
+func (db *DB) migrationsSchemaFile(compressed bool) string {
+	if !db.DumpSchemaPerTable {
+		return db.SchemaFile
+	}
+
+	_, table := splitMigrationsTable(db.migrationsTable())
+	path := filepath.Join(schemaTableDir(db.SchemaFile, compressed), table+".sql")
+	if compressed {
+		path += ".gz"
+	}
+
+	return path
+}
+
+// Line endings accepted by DB.NormalizeLineEndings
+const (
+	LineEndingsLF   = "lf"
+	LineEndingsCRLF = "crlf"
+)
+
+// wrapSchema surrounds a SQL schema dump with SchemaPrologue and SchemaEpilogue, each
+// on their own lines
+func (db *DB) wrapSchema(schema []byte) []byte {
+	if db.SchemaPrologue == "" && db.SchemaEpilogue == "" {
+		return schema
+	}
+
+	var buf bytes.Buffer
+	if db.SchemaPrologue != "" {
+		buf.WriteString(strings.TrimRight(db.SchemaPrologue, "\n") + "\n\n")
+	}
+	buf.Write(schema)
+	if db.SchemaEpilogue != "" {
+		if len(schema) > 0 && schema[len(schema)-1] != '\n' {
+			buf.WriteString("\n")
+		}
+		buf.WriteString("\n" + strings.TrimRight(db.SchemaEpilogue, "\n") + "\n")
+	}
+
+	return buf.Bytes()
+}
+
+// normalizeSchema converts the line endings of a schema dump to NormalizeLineEndings,
+// and ensures that it ends with exactly one newline. The dump is returned unchanged
+// when NormalizeLineEndings is empty.
+func (db *DB) normalizeSchema(schema []byte) ([]byte, error) {
+	var eol []byte
+	switch db.NormalizeLineEndings {
+	case "":
+		return schema, nil
+	case LineEndingsLF:
+		eol = []byte("\n")
+	case LineEndingsCRLF:
+		eol = []byte("\r\n")
+	default:
+		return nil, fmt.Errorf("invalid line endings `%s` (expected %s or %s)",
+			db.NormalizeLineEndings, LineEndingsLF, LineEndingsCRLF)
+	}
+
+	schema = bytes.ReplaceAll(schema, []byte("\r\n"), []byte("\n"))
+	schema = append(bytes.TrimRight(schema, "\r\n"), '\n')
+
+	return bytes.ReplaceAll(schema, []byte("\n"), eol), nil
+}
+
+// dumpSchemaPerTable writes the schema of each table to a separate file, and removes
+// files left over from tables which no longer exist
+func (db *DB) dumpSchemaPerTable(drv Driver, sqlDB *sql.DB, format string, compress bool) error {
+	if format != schemaFormatSQL {
+		return fmt.Errorf("per table schema dumps only support the sql format")
+	}
+
+	dumper, ok := drv.(TableSchemaDumper)
+	if !ok {
+		return fmt.Errorf("per table schema dumps are not supported by driver: %s", db.DatabaseURL.Scheme)
+	}
+
+	u, err := db.connectionURL()
+	if err != nil {
+		return err
+	}
+
+	schemas, err := dumper.DumpTableSchemas(u, sqlDB)
+	if err != nil {
+		return err
+	}
+
+	tables := []string{}
+	for table, schema := range schemas {
+		// record the driver, see checkSchemaDriver
+		schema = append(schemaDriverHeader(db.DatabaseURL.Scheme), db.wrapSchema(schema)...)
+		if schemas[table], err = db.normalizeSchema(schema); err != nil {
+			return err
+		}
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	// validate every table before writing any file
+	if db.SchemaValidate != nil {
+		for _, table := range tables {
+			if err := db.SchemaValidate(schemas[table]); err != nil {
+				return fmt.Errorf("schema validation failed for table `%s`: %s", table, err)
+			}
+		}
+	}
+
+	dir := schemaTableDir(db.SchemaFile, compress)
+	if err := ensureDir(db.fs(), dir, db.dirMode()); err != nil {
+		return err
+	}
+
+	ext := ".sql"
+	if compress {
+		ext += ".gz"
+	}
+
+	written := map[string]bool{}
+	for _, table := range tables {
+		path := filepath.Join(dir, table+ext)
+		db.logf("Writing: %s\n", path)
+		db.emit(Event{Type: EventDumping, Path: path})
+		if err := writeSchemaFile(db.fs(), path, schemas[table], compress, db.fileMode()); err != nil {
+			return err
+		}
+		written[path] = true
+	}
+
+	existing, err := fs.ReadDir(db.fs(), dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range existing {
+		path := filepath.Join(dir, entry.Name())
+		if !entry.IsDir() && strings.HasSuffix(path, ext) && !written[path] {
+			db.logf("Removing: %s\n", path)
+			if err := db.fs().Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// introspectSchema builds a structured schema using three driver specific queries:
+// tablesQuery returns table names, columnsQuery returns (name, type, nullable) for
+// the table passed as its only parameter, and indexesQuery returns
+// (index name, unique, column name) for the table, ordered by index and column position
+func introspectSchema(ctx context.Context, db *sql.DB, tablesQuery, columnsQuery, indexesQuery string) (*Schema, error) {
+	tables, err := queryColumnContext(ctx, db, tablesQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := &Schema{Tables: []SchemaTable{}}
+	for _, name := range tables {
+		table := SchemaTable{Name: name, Columns: []SchemaColumn{}, Indexes: []SchemaIndex{}}
+
+		if table.Columns, err = introspectColumns(ctx, db, columnsQuery, name); err != nil {
+			return nil, err
+		}
+		if table.Indexes, err = introspectIndexes(ctx, db, indexesQuery, name); err != nil {
+			return nil, err
+		}
+
+		schema.Tables = append(schema.Tables, table)
+	}
+
+	return schema, nil
+}
+
+func introspectColumns(ctx context.Context, db *sql.DB, query, table string) ([]SchemaColumn, error) {
+	rows, err := db.QueryContext(ctx, query, table)
+	if err != nil {
+		return nil, err
+	}
+	defer mustClose(rows)
+
+	columns := []SchemaColumn{}
+	for rows.Next() {
+		var col SchemaColumn
+		if err := rows.Scan(&col.Name, &col.Type, &col.Nullable); err != nil {
+			return nil, err
+		}
+
+		columns = append(columns, col)
+	}
+
+	return columns, rows.Err()
+}
+
+func introspectIndexes(ctx context.Context, db *sql.DB, query, table string) ([]SchemaIndex, error) {
+	rows, err := db.QueryContext(ctx, query, table)
+	if err != nil {
+		return nil, err
+	}
+	defer mustClose(rows)
+
+	indexes := []SchemaIndex{}
+	for rows.Next() {
+		var name, column string
+		var unique bool
+		if err := rows.Scan(&name, &unique, &column); err != nil {
+			return nil, err
+		}
+
+		// rows are ordered by index, so columns of the same index are adjacent
+		if n := len(indexes); n > 0 && indexes[n-1].Name == name {
+			indexes[n-1].Columns = append(indexes[n-1].Columns, column)
+			continue
+		}
+
+		indexes = append(indexes, SchemaIndex{Name: name, Unique: unique, Columns: []string{column}})
+	}
+
+	return indexes, rows.Err()
+}
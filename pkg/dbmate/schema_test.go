@@ -0,0 +1,54 @@
+package dbmate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaFileFormat(t *testing.T) {
+	cases := []struct {
+		path     string
+		format   string
+		compress bool
+	}{
+		{"./db/schema.sql", schemaFormatSQL, false},
+		{"./db/schema", schemaFormatSQL, false},
+		{"./db/schema.sql.gz", schemaFormatSQL, true},
+		{"./db/schema.json", schemaFormatJSON, false},
+		{"./db/SCHEMA.JSON.GZ", schemaFormatJSON, true},
+		{"./db/schema.gz", schemaFormatSQL, true},
+	}
+
+	for _, c := range cases {
+		format, compress := schemaFileFormat(c.path)
+		require.Equal(t, c.format, format, c.path)
+		require.Equal(t, c.compress, compress, c.path)
+	}
+}
+
+func TestNormalizeSchema(t *testing.T) {
+	db := DB{}
+
+	cases := []struct {
+		eol      string
+		input    string
+		expected string
+	}{
+		{"", "a\r\nb\n\n", "a\r\nb\n\n"},
+		{LineEndingsLF, "a\r\nb\nc", "a\nb\nc\n"},
+		{LineEndingsLF, "a\nb\r\n\r\n\n", "a\nb\n"},
+		{LineEndingsCRLF, "a\nb\r\nc\n\n", "a\r\nb\r\nc\r\n"},
+	}
+
+	for _, c := range cases {
+		db.NormalizeLineEndings = c.eol
+		schema, err := db.normalizeSchema([]byte(c.input))
+		require.NoError(t, err)
+		require.Equal(t, c.expected, string(schema), c.eol)
+	}
+
+	db.NormalizeLineEndings = "cr"
+	_, err := db.normalizeSchema([]byte("a\n"))
+	require.EqualError(t, err, "invalid line endings `cr` (expected lf or crlf)")
+}
@@ -0,0 +1,96 @@
+package dbmate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// sessionSetRegExp matches `SET [SESSION|LOCAL] name = value` and `SET name TO value`
+var sessionSetRegExp = regexp.MustCompile(`(?i)^\s*set\s+(session\s+|local\s+)?([\w.@]+)\s*(=|\s+to\s)`)
+
+// sessionRoleRegExp matches `SET [SESSION] ROLE name`
+var sessionRoleRegExp = regexp.MustCompile(`(?i)^\s*set\s+(session\s+)?role\s+\S+`)
+
+// sessionResetStatement returns the statement which restores the session variable
+// changed by stmt to its default value. An empty string is returned for transaction
+// scoped variables (`SET LOCAL`), which are reset automatically.
+func sessionResetStatement(stmt string) (string, error) {
+	if sessionRoleRegExp.MatchString(stmt) {
+		return "SET ROLE NONE", nil
+	}
+
+	match := sessionSetRegExp.FindStringSubmatch(stmt)
+	if match == nil {
+		return "", fmt.Errorf("unsupported session statement `%s`: expected `SET name = value`", stmt)
+	}
+
+	scope := strings.ToUpper(strings.TrimSpace(match[1]))
+	if scope == "LOCAL" {
+		return "", nil
+	}
+	if scope != "" {
+		scope += " "
+	}
+
+	return fmt.Sprintf("SET %s%s = DEFAULT", scope, match[2]), nil
+}
+
+// applySession executes session statements on tx, and returns a function which
+// resets them in reverse order. All statements are validated before any is executed.
+func applySession(tx Transaction, statements []string) (func() error, error) {
+	resets := make([]string, len(statements))
+	for i, stmt := range statements {
+		reset, err := sessionResetStatement(stmt)
+		if err != nil {
+			return nil, err
+		}
+		resets[i] = reset
+	}
+
+	applied := 0
+	reset := func() error {
+		var resetErr error
+		for i := applied - 1; i >= 0; i-- {
+			if resets[i] == "" {
+				continue
+			}
+			if _, err := tx.Exec(resets[i]); err != nil && resetErr == nil {
+				resetErr = err
+			}
+		}
+
+		return resetErr
+	}
+
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			_ = reset()
+			return nil, err
+		}
+		applied++
+	}
+
+	return reset, nil
+}
+
+// withSession applies session statements on tx, runs fn, and resets the session
+// afterwards. The session is reset even if fn fails, in which case the error
+// returned by fn takes precedence over any reset error.
+func withSession(tx Transaction, statements []string, fn func() error) error {
+	if len(statements) == 0 {
+		return fn()
+	}
+
+	reset, err := applySession(tx, statements)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(); err != nil {
+		_ = reset()
+		return err
+	}
+
+	return reset()
+}
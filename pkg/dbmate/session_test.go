@@ -0,0 +1,85 @@
+package dbmate
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// recordingTransaction records executed statements, and fails statements listed in failOn
+type recordingTransaction struct {
+	statements []string
+	failOn     map[string]bool
+}
+
+func (tx *recordingTransaction) Exec(query string, args ...interface{}) (sql.Result, error) {
+	tx.statements = append(tx.statements, query)
+	if tx.failOn[query] {
+		return nil, fmt.Errorf("failed: %s", query)
+	}
+
+	return nil, nil
+}
+
+func TestSessionResetStatement(t *testing.T) {
+	cases := map[string]string{
+		"SET search_path TO tenant_a":          "SET search_path = DEFAULT",
+		"set statement_timeout = 0":            "SET statement_timeout = DEFAULT",
+		"SET SESSION sql_mode = 'TRADITIONAL'": "SET SESSION sql_mode = DEFAULT",
+		"SET @@foreign_key_checks = 0":         "SET @@foreign_key_checks = DEFAULT",
+		"SET LOCAL lock_timeout = '1s'":        "",
+		"SET ROLE admin":                       "SET ROLE NONE",
+	}
+
+	for stmt, expected := range cases {
+		reset, err := sessionResetStatement(stmt)
+		require.NoError(t, err, stmt)
+		require.Equal(t, expected, reset, stmt)
+	}
+
+	_, err := sessionResetStatement("select 1")
+	require.EqualError(t, err, "unsupported session statement `select 1`: expected `SET name = value`")
+}
+
+func TestWithSession(t *testing.T) {
+	statements := []string{"SET a = 1", "SET LOCAL b = 2", "SET c TO 3"}
+
+	// session is reset in reverse order after fn
+	tx := &recordingTransaction{}
+	err := withSession(tx, statements, func() error {
+		_, err := tx.Exec("select 1")
+		return err
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"SET a = 1", "SET LOCAL b = 2", "SET c TO 3", "select 1",
+		"SET c = DEFAULT", "SET a = DEFAULT"}, tx.statements)
+
+	// session is reset when fn fails, and the original error is returned
+	tx = &recordingTransaction{failOn: map[string]bool{"SET a = DEFAULT": true}}
+	err = withSession(tx, statements, func() error {
+		return fmt.Errorf("migration failed")
+	})
+	require.EqualError(t, err, "migration failed")
+	require.Equal(t, []string{"SET a = 1", "SET LOCAL b = 2", "SET c TO 3",
+		"SET c = DEFAULT", "SET a = DEFAULT"}, tx.statements)
+
+	// statements applied before a failing statement are reset
+	tx = &recordingTransaction{failOn: map[string]bool{"SET c TO 3": true}}
+	err = withSession(tx, statements, func() error {
+		t.Fatal("fn should not be called")
+		return nil
+	})
+	require.EqualError(t, err, "failed: SET c TO 3")
+	require.Equal(t, []string{"SET a = 1", "SET LOCAL b = 2", "SET c TO 3",
+		"SET a = DEFAULT"}, tx.statements)
+
+	// unsupported statements are rejected before anything is executed
+	tx = &recordingTransaction{}
+	err = withSession(tx, []string{"SET a = 1", "select 1"}, func() error {
+		return nil
+	})
+	require.Error(t, err)
+	require.Empty(t, tx.statements)
+}
@@ -0,0 +1,65 @@
+package dbmate
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// ValidateAgainstShadow applies every migration to a throwaway shadow database,
+// to check that the migrations succeed before applying them to the real database.
+// The shadow database is created from scratch, and dropped afterwards even if a
+// migration fails. It must not already exist, so that an existing database is
+// never dropped by mistake. The database at DatabaseURL is not accessed.
+func (db *DB) ValidateAgainstShadow(shadowURL *url.URL) error {
+	return db.ValidateAgainstShadowContext(context.Background(), shadowURL)
+}
+
+// ValidateAgainstShadowContext is like ValidateAgainstShadow, but gives up as soon as
+// ctx is done. The shadow database is dropped even then.
+func (db *DB) ValidateAgainstShadowContext(ctx context.Context, shadowURL *url.URL) (err error) {
+	if shadowURL.String() == db.DatabaseURL.String() {
+		return fmt.Errorf("the shadow database must not be the database being migrated")
+	}
+
+	shadow := *db
+	shadow.DatabaseURL = shadowURL
+	shadow.AutoDumpSchema = false
+	shadow.DryRun = false
+	shadow.VersionFile = ""
+	shadow.AfterMigrateComplete = nil
+
+	drv, err := shadow.GetDriver()
+	if err != nil {
+		return err
+	}
+
+	u, err := shadow.connectionURL()
+	if err != nil {
+		return err
+	}
+
+	exists, err := drv.DatabaseExists(u)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("shadow database `%s` already exists", databaseName(shadowURL))
+	}
+
+	if err := shadow.CreateContext(ctx); err != nil {
+		return err
+	}
+	defer func() {
+		if dropErr := shadow.Drop(); dropErr != nil && err == nil {
+			err = fmt.Errorf("unable to drop shadow database: %s", dropErr)
+		}
+	}()
+
+	if err := shadow.MigrateContext(ctx); err != nil {
+		return fmt.Errorf("migrations failed on shadow database: %s", err)
+	}
+
+	db.logf("Migrations succeeded on shadow database\n")
+	return nil
+}
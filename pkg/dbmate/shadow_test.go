@@ -0,0 +1,55 @@
+package dbmate
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateAgainstShadow(t *testing.T) {
+	u := sqliteTestURL(t)
+	db := newTestDB(t, u)
+	shadowURL, err := url.Parse("sqlite3:////tmp/dbmate_shadow.sqlite3")
+	require.NoError(t, err)
+
+	err = db.Drop()
+	require.NoError(t, err)
+
+	// the shadow database is dropped after migrating
+	err = db.ValidateAgainstShadow(shadowURL)
+	require.NoError(t, err)
+	exists, err := SQLiteDriver{}.DatabaseExists(shadowURL)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	// the real database is not touched
+	exists, err = SQLiteDriver{}.DatabaseExists(u)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	// the shadow database is dropped when a migration fails
+	ver := "20300101000000"
+	require.NoError(t, db.RegisterGoMigration(ver, func(ctx context.Context, tx GoMigrationTx) error {
+		return errors.New("backfill failed")
+	}, nil))
+
+	err = db.ValidateAgainstShadow(shadowURL)
+	require.EqualError(t, err, "migrations failed on shadow database: backfill failed")
+	exists, err = SQLiteDriver{}.DatabaseExists(shadowURL)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	// existing databases are never used as shadow
+	err = db.ValidateAgainstShadow(u)
+	require.EqualError(t, err, "the shadow database must not be the database being migrated")
+
+	err = db.Create()
+	require.NoError(t, err)
+	shadowURL.Path = u.Path
+	shadowURL.Scheme = "sqlite"
+	err = db.ValidateAgainstShadow(shadowURL)
+	require.EqualError(t, err, "shadow database `"+databaseName(u)+"` already exists")
+}
@@ -4,12 +4,15 @@ package dbmate
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"fmt"
 	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3" // sqlite driver for database/sql
 )
@@ -21,6 +24,34 @@ func init() {
 
 // SQLiteDriver provides top level database functions
 type SQLiteDriver struct {
+	tableName string
+}
+
+// WithMigrationsTable returns a copy of the driver recording migrations in the named table
+func (drv SQLiteDriver) WithMigrationsTable(name string) Driver {
+	drv.tableName = name
+	return drv
+}
+
+// migrationsTable returns the name of the migrations table, which may be qualified
+// with the name of an attached database (e.g. `main.schema_migrations`)
+func (drv SQLiteDriver) migrationsTable() string {
+	if drv.tableName == "" {
+		return migrationsTableName
+	}
+
+	return drv.tableName
+}
+
+// migrationsSchemaAndTable returns the database of the migrations table, main unless
+// the name is qualified, and its unqualified name
+func (drv SQLiteDriver) migrationsSchemaAndTable() (string, string) {
+	schema, table := splitMigrationsTable(drv.migrationsTable())
+	if schema == "" {
+		schema = "main"
+	}
+
+	return schema, table
 }
 
 func sqlitePath(u *url.URL) string {
@@ -33,13 +64,21 @@ func sqlitePath(u *url.URL) string {
 
 // Open creates a new database connection
 func (drv SQLiteDriver) Open(u *url.URL) (*sql.DB, error) {
-	return sql.Open("sqlite3", sqlitePath(u))
+	return sql.Open(drv.dataSourceName(u))
+}
+
+// dataSourceName returns the database/sql driver name and data source name
+func (drv SQLiteDriver) dataSourceName(u *url.URL) (string, string) {
+	return "sqlite3", sqlitePath(u)
+}
+
+// databaseName returns the path of the database file
+func (drv SQLiteDriver) databaseName(u *url.URL) string {
+	return sqlitePath(u)
 }
 
 // CreateDatabase creates the specified database
 func (drv SQLiteDriver) CreateDatabase(u *url.URL) error {
-	fmt.Printf("Creating: %s\n", sqlitePath(u))
-
 	db, err := drv.Open(u)
 	if err != nil {
 		return err
@@ -52,7 +91,6 @@ func (drv SQLiteDriver) CreateDatabase(u *url.URL) error {
 // DropDatabase drops the specified database (if it exists)
 func (drv SQLiteDriver) DropDatabase(u *url.URL) error {
 	path := sqlitePath(u)
-	fmt.Printf("Dropping: %s\n", path)
 
 	exists, err := drv.DatabaseExists(u)
 	if err != nil {
@@ -65,20 +103,20 @@ func (drv SQLiteDriver) DropDatabase(u *url.URL) error {
 	return os.Remove(path)
 }
 
-func sqliteSchemaMigrationsDump(db *sql.DB) ([]byte, error) {
+func sqliteSchemaMigrationsDump(db *sql.DB, table string) ([]byte, error) {
 	// load applied migrations
 	migrations, err := queryColumn(db,
-		"select quote(version) from schema_migrations order by version asc")
+		"select quote(version) from "+table+" order by version asc")
 	if err != nil {
 		return nil, err
 	}
 
-	// build schema_migrations table data
+	// build migrations table data
 	var buf bytes.Buffer
 	buf.WriteString("-- Dbmate schema migrations\n")
 
 	if len(migrations) > 0 {
-		buf.WriteString("INSERT INTO schema_migrations (version) VALUES\n  (" +
+		buf.WriteString("INSERT INTO " + table + " (version) VALUES\n  (" +
 			strings.Join(migrations, "),\n  (") +
 			");\n")
 	}
@@ -94,7 +132,7 @@ func (drv SQLiteDriver) DumpSchema(u *url.URL, db *sql.DB) ([]byte, error) {
 		return nil, err
 	}
 
-	migrations, err := sqliteSchemaMigrationsDump(db)
+	migrations, err := sqliteSchemaMigrationsDump(db, drv.migrationsTable())
 	if err != nil {
 		return nil, err
 	}
@@ -103,6 +141,65 @@ func (drv SQLiteDriver) DumpSchema(u *url.URL, db *sql.DB) ([]byte, error) {
 	return trimLeadingSQLComments(schema)
 }
 
+// sqliteTablesQuery lists the tables in the database
+const sqliteTablesQuery = "select name from sqlite_master where type = 'table' " +
+	"and name not like 'sqlite_%' order by name"
+
+// DumpTableSchemas returns the schema of each table, including its indexes and triggers
+func (drv SQLiteDriver) DumpTableSchemas(u *url.URL, db *sql.DB) (map[string][]byte, error) {
+	tables, err := queryColumn(db, sqliteTablesQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	path := sqlitePath(u)
+	schemas := map[string][]byte{}
+	for _, table := range tables {
+		schema, err := runCommand("sqlite3", path, ".schema "+table)
+		if err != nil {
+			return nil, err
+		}
+
+		schemas[table] = schema
+	}
+
+	migrations, err := sqliteSchemaMigrationsDump(db, drv.migrationsTable())
+	if err != nil {
+		return nil, err
+	}
+	_, table := drv.migrationsSchemaAndTable()
+	schemas[table] = append(schemas[table], migrations...)
+
+	return schemas, nil
+}
+
+// IntrospectSchema returns a structured description of the current database schema
+func (drv SQLiteDriver) IntrospectSchema(ctx context.Context, u *url.URL, db *sql.DB) (*Schema, error) {
+	return introspectSchema(ctx, db, sqliteTablesQuery,
+		`select name, type, "notnull" = 0 from pragma_table_info(?) order by cid`,
+		`select il.name, il."unique", ii.name from pragma_index_list(?1) il `+
+			`join pragma_index_info(il.name) ii order by il.name, ii.seqno`)
+}
+
+// CheckFreeSpace verifies that at least minFreeBytes are available on the filesystem
+// holding the database file. Migrations which grow the database file can otherwise
+// fail mid-way and corrupt the database when the disk fills up.
+func (drv SQLiteDriver) CheckFreeSpace(u *url.URL, minFreeBytes uint64) error {
+	dir := filepath.Dir(sqlitePath(u))
+
+	free, err := freeDiskSpace(dir)
+	if err != nil {
+		return fmt.Errorf("unable to determine free disk space in `%s`: %s", dir, err)
+	}
+
+	if free < minFreeBytes {
+		return fmt.Errorf("insufficient free disk space in `%s`: "+
+			"%d bytes available, %d bytes required", dir, free, minFreeBytes)
+	}
+
+	return nil
+}
+
 // DatabaseExists determines whether the database exists
 func (drv SQLiteDriver) DatabaseExists(u *url.URL) (bool, error) {
 	_, err := os.Stat(sqlitePath(u))
@@ -116,9 +213,9 @@ func (drv SQLiteDriver) DatabaseExists(u *url.URL) (bool, error) {
 	return true, nil
 }
 
-// CreateMigrationsTable creates the schema_migrations table
+// CreateMigrationsTable creates the migrations table
 func (drv SQLiteDriver) CreateMigrationsTable(db *sql.DB) error {
-	_, err := db.Exec("create table if not exists schema_migrations " +
+	_, err := db.Exec("create table if not exists " + drv.migrationsTable() + " " +
 		"(version varchar(255) primary key)")
 
 	return err
@@ -127,7 +224,7 @@ func (drv SQLiteDriver) CreateMigrationsTable(db *sql.DB) error {
 // SelectMigrations returns a list of applied migrations
 // with an optional limit (in descending order)
 func (drv SQLiteDriver) SelectMigrations(db *sql.DB, limit int) (map[string]bool, error) {
-	query := "select version from schema_migrations order by version desc"
+	query := "select version from " + drv.migrationsTable() + " order by version desc"
 	if limit >= 0 {
 		query = fmt.Sprintf("%s limit %d", query, limit)
 	}
@@ -153,27 +250,216 @@ func (drv SQLiteDriver) SelectMigrations(db *sql.DB, limit int) (map[string]bool
 
 // InsertMigration adds a new migration record
 func (drv SQLiteDriver) InsertMigration(db Transaction, version string) error {
-	_, err := db.Exec("insert into schema_migrations (version) values (?)", version)
+	_, err := db.Exec("insert into "+drv.migrationsTable()+" (version) values (?)", version)
+
+	return err
+}
+
+// InsertMigrations adds multiple migration records using batched inserts
+func (drv SQLiteDriver) InsertMigrations(db Transaction, versions []string) error {
+	return insertMigrationBatches(db, "insert into "+drv.migrationsTable()+" (version) values", func(i int) string {
+		return "?"
+	}, versions)
+}
+
+// CreateChecksumColumn adds the checksum column to the migrations table
+func (drv SQLiteDriver) CreateChecksumColumn(db *sql.DB) error {
+	schema, table := drv.migrationsSchemaAndTable()
+	exists := 0
+	err := db.QueryRow("select count(*) from pragma_table_info(?, ?) "+
+		"where name = 'checksum'", table, schema).Scan(&exists)
+	if err != nil || exists > 0 {
+		return err
+	}
+
+	_, err = db.Exec("alter table " + drv.migrationsTable() + " add column checksum varchar(255)")
 
 	return err
 }
 
+// UpdateMigrationChecksum records the checksum of an applied migration
+func (drv SQLiteDriver) UpdateMigrationChecksum(db Transaction, version, checksum string) error {
+	_, err := db.Exec("update "+drv.migrationsTable()+" set checksum = ? where version = ?", checksum, version)
+
+	return err
+}
+
+// SelectMigrationChecksums returns the checksums of applied migrations
+func (drv SQLiteDriver) SelectMigrationChecksums(db *sql.DB) (map[string]string, error) {
+	return selectMigrationChecksums(db,
+		"select version, checksum from "+drv.migrationsTable()+" where checksum is not null")
+}
+
+// CreateBatchColumn adds the batch column to the migrations table
+func (drv SQLiteDriver) CreateBatchColumn(db *sql.DB) error {
+	schema, table := drv.migrationsSchemaAndTable()
+	exists := 0
+	err := db.QueryRow("select count(*) from pragma_table_info(?, ?) "+
+		"where name = 'batch'", table, schema).Scan(&exists)
+	if err != nil || exists > 0 {
+		return err
+	}
+
+	_, err = db.Exec("alter table " + drv.migrationsTable() + " add column batch varchar(255)")
+
+	return err
+}
+
+// UpdateMigrationBatch records the batch of an applied migration
+func (drv SQLiteDriver) UpdateMigrationBatch(db Transaction, version, batch string) error {
+	_, err := db.Exec("update "+drv.migrationsTable()+" set batch = ? where version = ?", batch, version)
+
+	return err
+}
+
+// SelectBatchMigrations returns the versions of the migrations applied in a batch
+func (drv SQLiteDriver) SelectBatchMigrations(db *sql.DB, batch string) ([]string, error) {
+	return queryColumn(db, "select version from "+drv.migrationsTable()+" where batch = ?", batch)
+}
+
+// CheckPermissions verifies that tables and indexes can be created, and migrations
+// recorded, in a transaction which is rolled back
+func (drv SQLiteDriver) CheckPermissions(ctx context.Context, db *sql.DB) ([]string, error) {
+	probes := []permissionProbe{
+		{
+			privilege:  privilegeCreateTable,
+			statements: []string{"create table " + permissionProbeTable + " (id integer)"},
+		},
+		{
+			privilege: privilegeCreateIndex,
+			statements: []string{"create index " + permissionProbeTable + "_idx " +
+				"on " + permissionProbeTable + " (id)"},
+			dependent: true,
+		},
+	}
+
+	exists := 0
+	schema, table := drv.migrationsSchemaAndTable()
+	err := db.QueryRowContext(ctx, "select count(*) from "+schema+".sqlite_master where type = 'table' and name = ?",
+		table).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists > 0 {
+		probes = append(probes, permissionProbe{
+			privilege: migrationsTablePrivilege(drv.migrationsTable()),
+			statements: []string{"insert into " + drv.migrationsTable() + " (version) " +
+				"values ('" + permissionProbeVersion + "')"},
+		})
+	}
+
+	return runPermissionProbes(ctx, db, probes)
+}
+
+// CreateTimingColumns adds the applied_at and duration_ms columns to the migrations table
+func (drv SQLiteDriver) CreateTimingColumns(db *sql.DB) error {
+	schema, table := drv.migrationsSchemaAndTable()
+	exists := 0
+	err := db.QueryRow("select count(*) from pragma_table_info(?, ?) "+
+		"where name = 'applied_at'", table, schema).Scan(&exists)
+	if err != nil || exists > 0 {
+		return err
+	}
+
+	_, err = db.Exec("alter table " + drv.migrationsTable() + " add column applied_at datetime")
+	if err == nil {
+		_, err = db.Exec("alter table " + drv.migrationsTable() + " add column duration_ms bigint")
+	}
+
+	return err
+}
+
+// UpdateMigrationTiming records when a migration was applied, and how long it took
+func (drv SQLiteDriver) UpdateMigrationTiming(db Transaction, version string, appliedAt time.Time,
+	duration time.Duration) error {
+	_, err := db.Exec("update "+drv.migrationsTable()+" set applied_at = ?, duration_ms = ? where version = ?",
+		appliedAt.UTC(), duration.Milliseconds(), version)
+
+	return err
+}
+
+// SelectMigrationTimings returns the timings of applied migrations
+func (drv SQLiteDriver) SelectMigrationTimings(ctx context.Context, db *sql.DB) ([]TimingRecord, error) {
+	return selectMigrationTimings(ctx, db,
+		"select version, applied_at, duration_ms from "+drv.migrationsTable())
+}
+
+// CreateAuditTable creates the audit table, if it does not already exist
+func (drv SQLiteDriver) CreateAuditTable(db *sql.DB, table string) error {
+	_, err := db.Exec(fmt.Sprintf("create table if not exists %s (id integer primary key autoincrement, "+
+		"version varchar(255) not null, direction varchar(4) not null, "+
+		"success boolean not null, created_at datetime not null)", table))
+
+	return err
+}
+
+// InsertAuditRecord appends a record to the audit table
+func (drv SQLiteDriver) InsertAuditRecord(db Transaction, table string, record AuditRecord) error {
+	_, err := db.Exec(fmt.Sprintf("insert into %s (version, direction, success, created_at) "+
+		"values (?, ?, ?, ?)", table), record.Version, record.Direction, record.Success, record.Time)
+
+	return err
+}
+
+// CheckForeignKeys returns the rows which reference a missing parent row
+func (drv SQLiteDriver) CheckForeignKeys(db *sql.DB) ([]string, error) {
+	rows, err := db.Query("pragma foreign_key_check")
+	if err != nil {
+		return nil, err
+	}
+	defer mustClose(rows)
+
+	violations := []string{}
+	for rows.Next() {
+		var table, parent string
+		var rowid sql.NullInt64
+		var fkid int
+		if err := rows.Scan(&table, &rowid, &parent, &fkid); err != nil {
+			return nil, err
+		}
+
+		if rowid.Valid {
+			violations = append(violations, fmt.Sprintf("`%s` row %d references a missing row in `%s`",
+				table, rowid.Int64, parent))
+		} else {
+			violations = append(violations, fmt.Sprintf("`%s` references a missing row in `%s`",
+				table, parent))
+		}
+	}
+
+	return violations, rows.Err()
+}
+
 // DeleteMigration removes a migration record
 func (drv SQLiteDriver) DeleteMigration(db Transaction, version string) error {
-	_, err := db.Exec("delete from schema_migrations where version = ?", version)
+	_, err := db.Exec("delete from "+drv.migrationsTable()+" where version = ?", version)
 
 	return err
 }
 
+// Capabilities returns the features supported by the driver
+func (drv SQLiteDriver) Capabilities() DriverCapabilities {
+	return DriverCapabilities{
+		TransactionalDDL:   true,
+		MultiStatementExec: true,
+		ServerAccess:       false,
+	}
+}
+
 // Ping verifies a connection to the database. Due to the way SQLite works, by
 // testing whether the database is valid, it will automatically create the database
 // if it does not already exist.
 func (drv SQLiteDriver) Ping(u *url.URL) error {
+	return drv.PingContext(context.Background(), u)
+}
+
+// PingContext is like Ping, but gives up as soon as ctx is done
+func (drv SQLiteDriver) PingContext(ctx context.Context, u *url.URL) error {
 	db, err := drv.Open(u)
 	if err != nil {
 		return err
 	}
 	defer mustClose(db)
 
-	return db.Ping()
+	return db.PingContext(ctx)
 }
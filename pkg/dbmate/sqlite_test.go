@@ -3,7 +3,10 @@
 package dbmate
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"math"
 	"net/url"
 	"os"
 	"testing"
@@ -97,6 +100,75 @@ func TestSQLiteDumpSchema(t *testing.T) {
 		"unable to open database file")
 }
 
+func TestSQLiteDumpTableSchemas(t *testing.T) {
+	drv := SQLiteDriver{}
+	u := sqliteTestURL(t)
+
+	// prepare database
+	db := prepTestSQLiteDB(t)
+	defer mustClose(db)
+	err := drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+	err = drv.InsertMigration(db, "abc1")
+	require.NoError(t, err)
+	_, err = db.Exec("create table users (id integer primary key, name text)")
+	require.NoError(t, err)
+
+	// DumpTableSchemas should return the schema of each table
+	schemas, err := drv.DumpTableSchemas(u, db)
+	require.NoError(t, err)
+	require.Len(t, schemas, 2)
+	require.Contains(t, string(schemas["users"]), "CREATE TABLE users")
+	require.NotContains(t, string(schemas["users"]), "schema_migrations")
+	require.Contains(t, string(schemas["schema_migrations"]), "CREATE TABLE schema_migrations")
+	require.Contains(t, string(schemas["schema_migrations"]), "-- Dbmate schema migrations\n"+
+		"INSERT INTO schema_migrations (version) VALUES\n"+
+		"  ('abc1');\n")
+}
+
+func TestSQLiteIntrospectSchema(t *testing.T) {
+	drv := SQLiteDriver{}
+	u := sqliteTestURL(t)
+
+	// prepare database
+	db := prepTestSQLiteDB(t)
+	defer mustClose(db)
+	err := drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+	_, err = db.Exec(`create table users (id integer not null, name varchar(255), email text);
+		create unique index users_email_name on users (email, name)`)
+	require.NoError(t, err)
+
+	schema, err := drv.IntrospectSchema(context.Background(), u, db)
+	require.NoError(t, err)
+	require.Len(t, schema.Tables, 2)
+	require.Equal(t, "schema_migrations", schema.Tables[0].Name)
+
+	users := schema.Tables[1]
+	require.Equal(t, "users", users.Name)
+	require.Equal(t, []SchemaColumn{
+		{Name: "id", Type: "integer", Nullable: false},
+		{Name: "name", Type: "varchar(255)", Nullable: true},
+		{Name: "email", Type: "text", Nullable: true},
+	}, users.Columns)
+	require.Equal(t, []SchemaIndex{
+		{Name: "users_email_name", Unique: true, Columns: []string{"email", "name"}},
+	}, users.Indexes)
+}
+
+func TestSQLiteCheckFreeSpace(t *testing.T) {
+	drv := SQLiteDriver{}
+	u := sqliteTestURL(t)
+
+	err := drv.CheckFreeSpace(u, 1)
+	require.NoError(t, err)
+
+	err = drv.CheckFreeSpace(u, math.MaxUint64)
+	require.Error(t, err)
+	require.Regexp(t, "^insufficient free disk space in `/tmp`: "+
+		`\d+ bytes available, 18446744073709551615 bytes required$`, err.Error())
+}
+
 func TestSQLiteDatabaseExists(t *testing.T) {
 	drv := SQLiteDriver{}
 	u := sqliteTestURL(t)
@@ -192,6 +264,105 @@ func TestSQLiteInsertMigration(t *testing.T) {
 	require.Equal(t, 1, count)
 }
 
+func TestSQLiteInsertMigrations(t *testing.T) {
+	drv := SQLiteDriver{}
+	db := prepTestSQLiteDB(t)
+	defer mustClose(db)
+
+	err := drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+
+	// more versions than bind parameters allowed in a single statement
+	versions := []string{}
+	for i := 0; i < 1200; i++ {
+		versions = append(versions, fmt.Sprintf("abc%d", i))
+	}
+
+	err = drv.InsertMigrations(db, versions)
+	require.NoError(t, err)
+
+	count := 0
+	err = db.QueryRow("select count(*) from schema_migrations").Scan(&count)
+	require.NoError(t, err)
+	require.Equal(t, 1200, count)
+}
+
+func TestSQLiteMigrationChecksums(t *testing.T) {
+	drv := SQLiteDriver{}
+	db := prepTestSQLiteDB(t)
+	defer mustClose(db)
+
+	err := drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+
+	// creating the column twice is allowed
+	err = drv.CreateChecksumColumn(db)
+	require.NoError(t, err)
+	err = drv.CreateChecksumColumn(db)
+	require.NoError(t, err)
+
+	err = drv.InsertMigrations(db, []string{"abc1", "abc2"})
+	require.NoError(t, err)
+	err = drv.UpdateMigrationChecksum(db, "abc1", "123abc")
+	require.NoError(t, err)
+
+	checksums, err := drv.SelectMigrationChecksums(db)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"abc1": "123abc"}, checksums)
+}
+
+func TestSQLiteMigrationBatches(t *testing.T) {
+	drv := SQLiteDriver{}
+	db := prepTestSQLiteDB(t)
+	defer mustClose(db)
+
+	err := drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+
+	// creating the column twice is allowed
+	err = drv.CreateBatchColumn(db)
+	require.NoError(t, err)
+	err = drv.CreateBatchColumn(db)
+	require.NoError(t, err)
+
+	err = drv.InsertMigrations(db, []string{"abc1", "abc2", "abc3"})
+	require.NoError(t, err)
+	err = drv.UpdateMigrationBatch(db, "abc1", "batch1")
+	require.NoError(t, err)
+	err = drv.UpdateMigrationBatch(db, "abc2", "batch1")
+	require.NoError(t, err)
+
+	versions, err := drv.SelectBatchMigrations(db, "batch1")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"abc1", "abc2"}, versions)
+
+	versions, err = drv.SelectBatchMigrations(db, "batch2")
+	require.NoError(t, err)
+	require.Empty(t, versions)
+}
+
+func TestSQLiteCheckForeignKeys(t *testing.T) {
+	drv := SQLiteDriver{}
+	db := prepTestSQLiteDB(t)
+	defer mustClose(db)
+
+	_, err := db.Exec("create table users (id integer primary key);" +
+		"create table posts (id integer primary key, user_id integer references users (id));" +
+		"insert into users (id) values (1);" +
+		"insert into posts (id, user_id) values (1, 1), (2, 2)")
+	require.NoError(t, err)
+
+	violations, err := drv.CheckForeignKeys(db)
+	require.NoError(t, err)
+	require.Equal(t, []string{"`posts` row 2 references a missing row in `users`"}, violations)
+
+	_, err = db.Exec("delete from posts where id = 2")
+	require.NoError(t, err)
+	violations, err = drv.CheckForeignKeys(db)
+	require.NoError(t, err)
+	require.Empty(t, violations)
+}
+
 func TestSQLiteDeleteMigration(t *testing.T) {
 	drv := SQLiteDriver{}
 	db := prepTestSQLiteDB(t)
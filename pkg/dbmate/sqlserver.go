@@ -0,0 +1,336 @@
+package dbmate
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+
+	_ "github.com/denisenkom/go-mssqldb" // sqlserver driver for database/sql
+	"github.com/denisenkom/go-mssqldb/batch"
+)
+
+func init() {
+	RegisterDriver(SQLServerDriver{}, "sqlserver")
+}
+
+// sqlServerBatchSeparator separates the batches of a script, as in sqlcmd
+const sqlServerBatchSeparator = "GO"
+
+// sqlServerMaxInsertRows is the number of rows SQL Server accepts in the VALUES list
+// of an insert statement
+const sqlServerMaxInsertRows = 1000
+
+// SQLServerDriver provides top level database functions
+type SQLServerDriver struct {
+	tableName string
+}
+
+// WithMigrationsTable returns a copy of the driver recording migrations in the named table
+func (drv SQLServerDriver) WithMigrationsTable(name string) Driver {
+	drv.tableName = name
+	return drv
+}
+
+// migrationsTable returns the name of the migrations table, as configured
+func (drv SQLServerDriver) migrationsTable() string {
+	if drv.tableName == "" {
+		return migrationsTableName
+	}
+
+	return drv.tableName
+}
+
+// quotedMigrationsTable returns the name of the migrations table, quoted for use in
+// statements. Unless the name is qualified, the table is in the default schema of the
+// user.
+func (drv SQLServerDriver) quotedMigrationsTable() string {
+	schema, table := splitMigrationsTable(drv.migrationsTable())
+	if schema == "" {
+		return sqlServerQuoteIdentifier(table)
+	}
+
+	return sqlServerQuoteIdentifier(schema) + "." + sqlServerQuoteIdentifier(table)
+}
+
+// normalizeSQLServerURL moves the database name from the path of the URL, where
+// dbmate expects it, to the database parameter expected by go-mssqldb (which reads
+// the path as an instance name)
+func normalizeSQLServerURL(u *url.URL) string {
+	normalizedURL := *u
+	normalizedURL.Path = ""
+	normalizedURL.RawPath = ""
+
+	query := u.Query()
+	if name := databaseName(u); name != "" {
+		query.Set("database", name)
+	} else {
+		query.Del("database")
+	}
+	normalizedURL.RawQuery = query.Encode()
+
+	return normalizedURL.String()
+}
+
+// Open creates a new database connection
+func (drv SQLServerDriver) Open(u *url.URL) (*sql.DB, error) {
+	return sql.Open(drv.dataSourceName(u))
+}
+
+// dataSourceName returns the database/sql driver name and data source name
+func (drv SQLServerDriver) dataSourceName(u *url.URL) (string, string) {
+	return "sqlserver", normalizeSQLServerURL(u)
+}
+
+func (drv SQLServerDriver) openRootDB(u *url.URL) (*sql.DB, error) {
+	// connect to the default database of the login
+	rootURL := *u
+	rootURL.Path = "/"
+
+	return drv.Open(&rootURL)
+}
+
+func sqlServerQuoteIdentifier(str string) string {
+	str = strings.Replace(str, "]", "]]", -1)
+
+	return fmt.Sprintf("[%s]", str)
+}
+
+// CreateDatabase creates the specified database
+func (drv SQLServerDriver) CreateDatabase(u *url.URL) error {
+	name := databaseName(u)
+
+	db, err := drv.openRootDB(u)
+	if err != nil {
+		return err
+	}
+	defer mustClose(db)
+
+	_, err = db.Exec(fmt.Sprintf("create database %s",
+		sqlServerQuoteIdentifier(name)))
+
+	return err
+}
+
+// DropDatabase drops the specified database (if it exists)
+func (drv SQLServerDriver) DropDatabase(u *url.URL) error {
+	name := databaseName(u)
+
+	db, err := drv.openRootDB(u)
+	if err != nil {
+		return err
+	}
+	defer mustClose(db)
+
+	_, err = db.Exec(fmt.Sprintf("if db_id(@p1) is not null drop database %s",
+		sqlServerQuoteIdentifier(name)), name)
+
+	return err
+}
+
+func sqlServerScripterArgs(u *url.URL) []string {
+	// generate CLI arguments
+	args := []string{"--exclude-headers", "--exclude-use-database"}
+
+	server := u.Hostname()
+	if server == "" {
+		server = "localhost"
+	}
+	if port := u.Port(); port != "" {
+		// mssql-scripter separates the port with a comma
+		server = server + "," + port
+	}
+	args = append(args, "-S", server)
+
+	if username := u.User.Username(); username != "" {
+		args = append(args, "-U", username)
+	}
+	if password, set := u.User.Password(); set {
+		args = append(args, "-P", password)
+	}
+
+	// add database name
+	args = append(args, "-d", databaseName(u))
+
+	return args
+}
+
+func sqlServerSchemaMigrationsDump(db *sql.DB, table string) ([]byte, error) {
+	// load applied migrations
+	migrations, err := queryColumn(db,
+		"select version from "+table+" order by version asc")
+	if err != nil {
+		return nil, err
+	}
+
+	return sqlServerMigrationsInserts(table, migrations), nil
+}
+
+// sqlServerMigrationsInserts builds the migrations table data of a schema dump, with
+// an insert statement for each chunk of sqlServerMaxInsertRows versions
+func sqlServerMigrationsInserts(table string, migrations []string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("\n--\n-- Dbmate schema migrations\n--\n\n")
+
+	for start := 0; start < len(migrations); start += sqlServerMaxInsertRows {
+		end := start + sqlServerMaxInsertRows
+		if end > len(migrations) {
+			end = len(migrations)
+		}
+
+		rows := make([]string, 0, end-start)
+		for _, ver := range migrations[start:end] {
+			rows = append(rows, "'"+strings.Replace(ver, "'", "''", -1)+"'")
+		}
+
+		buf.WriteString("INSERT INTO " + table + " (version) VALUES\n    (" +
+			strings.Join(rows, "),\n    (") +
+			");\n" + sqlServerBatchSeparator + "\n")
+	}
+
+	return buf.Bytes()
+}
+
+// DumpSchema returns the current database schema, as scripted by mssql-scripter
+func (drv SQLServerDriver) DumpSchema(u *url.URL, db *sql.DB) ([]byte, error) {
+	schema, err := runCommand("mssql-scripter", sqlServerScripterArgs(u)...)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := sqlServerSchemaMigrationsDump(db, drv.quotedMigrationsTable())
+	if err != nil {
+		return nil, err
+	}
+
+	schema = append(schema, migrations...)
+	return trimLeadingSQLComments(schema)
+}
+
+// DatabaseExists determines whether the database exists
+func (drv SQLServerDriver) DatabaseExists(u *url.URL) (bool, error) {
+	name := databaseName(u)
+
+	db, err := drv.openRootDB(u)
+	if err != nil {
+		return false, err
+	}
+	defer mustClose(db)
+
+	exists := 0
+	err = db.QueryRow("select count(*) from sys.databases where name = @p1", name).Scan(&exists)
+
+	return exists > 0, err
+}
+
+// CreateMigrationsTable creates the migrations table
+func (drv SQLServerDriver) CreateMigrationsTable(db *sql.DB) error {
+	_, err := db.Exec("if object_id(@p1, 'U') is null "+
+		"create table "+drv.quotedMigrationsTable()+" (version varchar(255) primary key)",
+		drv.quotedMigrationsTable())
+
+	return err
+}
+
+// SelectMigrations returns a list of applied migrations
+// with an optional limit (in descending order)
+func (drv SQLServerDriver) SelectMigrations(db *sql.DB, limit int) (map[string]bool, error) {
+	query := "select version from " + drv.quotedMigrationsTable() + " order by version desc"
+	if limit >= 0 {
+		query = fmt.Sprintf("select top (%d) version from %s order by version desc",
+			limit, drv.quotedMigrationsTable())
+	}
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer mustClose(rows)
+
+	migrations := map[string]bool{}
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+
+		migrations[version] = true
+	}
+
+	return migrations, nil
+}
+
+// InsertMigration adds a new migration record
+func (drv SQLServerDriver) InsertMigration(db Transaction, version string) error {
+	_, err := db.Exec("insert into "+drv.quotedMigrationsTable()+" (version) values (@p1)", version)
+
+	return err
+}
+
+// InsertMigrations adds multiple migration records using batched inserts
+func (drv SQLServerDriver) InsertMigrations(db Transaction, versions []string) error {
+	return insertMigrationBatches(db, "insert into "+drv.quotedMigrationsTable()+" (version) values", func(i int) string {
+		return fmt.Sprintf("@p%d", i+1)
+	}, versions)
+}
+
+// DeleteMigration removes a migration record
+func (drv SQLServerDriver) DeleteMigration(db Transaction, version string) error {
+	_, err := db.Exec("delete from "+drv.quotedMigrationsTable()+" where version = @p1", version)
+
+	return err
+}
+
+// SplitStatements splits a script into the batches separated by `GO` lines, as sqlcmd
+// does. A `GO <count>` line repeats the preceding batch count times. Empty batches are
+// skipped.
+func (drv SQLServerDriver) SplitStatements(script string) []string {
+	statements := []string{}
+	for _, statement := range batch.Split(script, sqlServerBatchSeparator) {
+		if strings.TrimSpace(statement) != "" {
+			statements = append(statements, statement)
+		}
+	}
+
+	return statements
+}
+
+// ExecScript executes the batches of a script separated by `GO` lines one at a time,
+// since `GO` is not understood by the server
+func (drv SQLServerDriver) ExecScript(tx Transaction, script string) error {
+	for _, statement := range drv.SplitStatements(script) {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Capabilities returns the features supported by the driver
+func (drv SQLServerDriver) Capabilities() DriverCapabilities {
+	return DriverCapabilities{
+		TransactionalDDL:   true,
+		MultiStatementExec: true,
+		ServerAccess:       true,
+	}
+}
+
+// Ping verifies a connection to the database server. It does not verify whether the
+// specified database exists.
+func (drv SQLServerDriver) Ping(u *url.URL) error {
+	return drv.PingContext(context.Background(), u)
+}
+
+// PingContext is like Ping, but gives up as soon as ctx is done
+func (drv SQLServerDriver) PingContext(ctx context.Context, u *url.URL) error {
+	db, err := drv.openRootDB(u)
+	if err != nil {
+		return err
+	}
+	defer mustClose(db)
+
+	return db.PingContext(ctx)
+}
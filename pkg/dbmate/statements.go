@@ -0,0 +1,142 @@
+package dbmate
+
+import (
+	"regexp"
+	"strings"
+)
+
+const endOfStatement = ";"
+
+// delimiterDirectiveRegExp matches a directive changing the statement delimiter,
+// e.g. `-- dbmate:delimiter $$`
+var delimiterDirectiveRegExp = regexp.MustCompile(`^--\s*dbmate:delimiter\s+(\S+)\s*$`)
+
+// parseStatements splits a script into statements for the DBMate engine.
+// Statements are terminated by a semicolon, except for semicolons found inside:
+//
+//   - single quoted strings ('a;b'), double quoted identifiers ("a;b")
+//     and backtick quoted identifiers (`a;b`). Within single and double quotes,
+//     a backslash escapes the next character ('it\'s;'), as in MySQL strings
+//     and PostgreSQL escape strings (E'it\'s;').
+//   - dollar quoted strings ($$a;b$$ or $tag$a;b$tag$)
+//   - line comments (-- a;b) and block comments (/* a;b */)
+//
+// Statements are returned as written, comments included. Statements containing
+// nothing but whitespace and comments are skipped.
+//
+// Stored procedures, functions and triggers contain semicolons of their own. For these,
+// a `-- dbmate:delimiter <delimiter>` line changes the delimiter for subsequent
+// statements, until it is reset with `-- dbmate:delimiter ;`. The directive line also
+// terminates any statement preceding it.
+func parseStatements(script string) []string {
+	var (
+		statements []string
+		start      int
+		hasContent bool
+		delimiter  = endOfStatement
+	)
+
+	for i := 0; i < len(script); {
+		switch {
+		case strings.HasPrefix(script[i:], "--"):
+			end := skipUntil(script, i+2, "\n")
+			lineStart := i == 0 || script[i-1] == '\n'
+			if m := delimiterDirectiveRegExp.FindStringSubmatch(script[i:end]); lineStart && m != nil {
+				if hasContent {
+					statements = append(statements, script[start:i])
+				}
+				delimiter = m[1]
+				start = end
+				hasContent = false
+			}
+			i = end
+			continue
+		case strings.HasPrefix(script[i:], "/*"):
+			i = skipUntil(script, i+2, "*/")
+			continue
+		case strings.HasPrefix(script[i:], delimiter):
+			if hasContent {
+				statements = append(statements, script[start:i])
+			}
+			i += len(delimiter)
+			start = i
+			hasContent = false
+			continue
+		}
+
+		switch c := script[i]; c {
+		case '\'', '"':
+			i = skipQuoted(script, i+1, c)
+		case '`':
+			i = skipUntil(script, i+1, "`")
+		case '$':
+			if tag := dollarQuoteTag(script[i:]); tag != "" {
+				i = skipUntil(script, i+len(tag), tag)
+			} else {
+				i++
+			}
+		default:
+			i++
+		}
+
+		if !strings.ContainsAny(script[i-1:i], " \t\r\n") {
+			hasContent = true
+		}
+	}
+
+	if hasContent {
+		statements = append(statements, script[start:])
+	}
+
+	return statements
+}
+
+// skipUntil returns the index following the first occurrence of end in s,
+// searching from index i, or len(s) if end is not found
+func skipUntil(s string, i int, end string) int {
+	if i > len(s) {
+		return len(s)
+	}
+
+	n := strings.Index(s[i:], end)
+	if n < 0 {
+		return len(s)
+	}
+
+	return i + n + len(end)
+}
+
+// skipQuoted returns the index following the quote closing a string which starts at
+// index i, or len(s) if it is not closed. Characters preceded by a backslash are
+// skipped, so that escaped quotes do not close the string.
+func skipQuoted(s string, i int, quote byte) int {
+	for ; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case quote:
+			return i + 1
+		}
+	}
+
+	return len(s)
+}
+
+// dollarQuoteTag returns the dollar quote tag (e.g. `$$` or `$body$`) at the start
+// of s, or an empty string if s does not start with a dollar quote. Tags follow
+// identifier rules, so positional parameters such as `$1` are not dollar quotes.
+func dollarQuoteTag(s string) string {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '$':
+			return s[:i+1]
+		case c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z'):
+		case '0' <= c && c <= '9' && i > 1:
+		default:
+			return ""
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,76 @@
+package dbmate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStatements(t *testing.T) {
+	// It splits statements on semicolons
+	require.Equal(t, []string{
+		"-- migrate:up\ncreate table users (id integer)",
+		"\ninsert into users (id) values (1)",
+	}, parseStatements("-- migrate:up\ncreate table users (id integer);\ninsert into users (id) values (1);\n"))
+
+	// It ignores semicolons inside quotes
+	require.Equal(t, []string{
+		"insert into t values ('a;b', 'it''s;')",
+		` select "weird;name", ` + "`other;name`" + ` from t`,
+	}, parseStatements("insert into t values ('a;b', 'it''s;'); select \"weird;name\", `other;name` from t;"))
+
+	// It ignores backslash escaped quotes inside strings
+	require.Equal(t, []string{
+		`insert into t values ('it\'s; ok', "say \"hi;\"", E'\\')`,
+		` insert into t values (E'it\'s; ok')`,
+	}, parseStatements(`insert into t values ('it\'s; ok', "say \"hi;\"", E'\\'); `+
+		`insert into t values (E'it\'s; ok');`))
+
+	// It ignores semicolons inside dollar quotes
+	function := `create function f() returns int as $$ begin return 1; end; $$ language plpgsql`
+	tagged := `create function g() returns int as $body$ select $1; $$; $body$ language sql`
+	require.Equal(t, []string{function, "\n" + tagged},
+		parseStatements(function+";\n"+tagged+";"))
+
+	// It ignores semicolons inside comments
+	require.Equal(t, []string{
+		"-- first; comment\ncreate table a (id integer)",
+		" /* block; comment */ create table b (id integer)",
+	}, parseStatements("-- first; comment\ncreate table a (id integer); /* block; comment */ create table b (id integer);"))
+
+	// It skips empty and comment only statements, and keeps a trailing statement
+	require.Equal(t, []string{"\nselect 1"},
+		parseStatements("-- migrate:down\n;;\n/* nothing */;\nselect 1"))
+	require.Empty(t, parseStatements("-- migrate:down\n"))
+
+	// It does not treat positional parameters as dollar quotes
+	require.Equal(t, []string{"select $1", " select 2"},
+		parseStatements("select $1; select 2;"))
+
+	// It supports changing the delimiter for stored procedures
+	script := `-- migrate:up
+create table t (id integer);
+-- dbmate:delimiter //
+create trigger t_ins before insert on t for each row
+begin
+  set new.id = new.id + 1;
+end//
+-- dbmate:delimiter ;
+insert into t values (1);
+`
+	require.Equal(t, []string{
+		"-- migrate:up\ncreate table t (id integer)",
+		"create trigger t_ins before insert on t for each row\nbegin\n  set new.id = new.id + 1;\nend",
+		"insert into t values (1)",
+	}, parseStatements(script))
+
+	// The delimiter directive terminates the preceding statement,
+	// and is only recognized at the start of a line
+	require.Equal(t, []string{
+		"select 1\n",
+		"select 2; -- dbmate:delimiter //\nselect 3",
+	}, parseStatements("select 1\n-- dbmate:delimiter $$\nselect 2; -- dbmate:delimiter //\nselect 3$$"))
+
+	// It tolerates unterminated quotes
+	require.Equal(t, []string{"select 'a;b"}, parseStatements("select 'a;b"))
+}
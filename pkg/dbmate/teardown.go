@@ -0,0 +1,105 @@
+package dbmate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// TeardownScript writes a SQL script which rolls back every applied migration,
+// newest first. Each migration contributes its down block between boundary comments,
+// followed by the statement the driver uses to delete its record from the migrations
+// table. The script is meant to be reviewed before it is run. No migration is rolled
+// back by this method, but like Status, it creates the migrations table if missing.
+//
+// Applied migrations whose down block cannot be written as SQL (Go migrations, and
+// migrations whose file no longer exists) are flagged with a warning comment.
+func (db *DB) TeardownScript(w io.Writer) error {
+	return db.TeardownScriptContext(context.Background(), w)
+}
+
+// TeardownScriptContext is like TeardownScript, but gives up as soon as ctx is done
+func (db *DB) TeardownScriptContext(ctx context.Context, w io.Writer) error {
+	drv, err := db.GetDriver()
+	if err != nil {
+		return err
+	}
+
+	results, err := db.StatusResultsContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := len(results) - 1; i >= 0; i-- {
+		res := results[i]
+		if !res.Applied {
+			continue
+		}
+
+		name := res.Filename
+		if res.Orphaned {
+			name = res.Version
+		}
+
+		var down string
+		switch {
+		case res.Orphaned:
+			down = "-- WARNING: migration file not found, the down migration is unknown"
+		case isGoMigration(res.Filename):
+			down = "-- WARNING: Go migration, the down migration is not available as SQL"
+		default:
+			_, migration, err := db.loadMigration(res.Filename)
+			if err != nil {
+				return err
+			}
+			down = strings.TrimSpace(migration.Contents)
+		}
+
+		deleteStatement, err := deleteMigrationStatement(drv, res.Version)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(w, "-- begin rollback: %s\n%s\n%s;\n-- end rollback: %s\n\n",
+			name, down, deleteStatement, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sqlPlaceholderRegExp matches the bind parameters used by drivers (`?`, `$1` or `:v`)
+var sqlPlaceholderRegExp = regexp.MustCompile(`\?|\$\d+|:\w+`)
+
+// deleteMigrationStatement returns the statement used by the driver to delete the
+// record of a migration, with the version inlined as a string literal
+func deleteMigrationStatement(drv Driver, version string) (string, error) {
+	tx := &statementRecorder{}
+	if err := drv.DeleteMigration(tx, version); err != nil {
+		return "", err
+	}
+	if len(tx.statements) != 1 {
+		return "", fmt.Errorf("unable to determine the statement deleting migration records")
+	}
+
+	literal := "'" + strings.Replace(version, "'", "''", -1) + "'"
+
+	return sqlPlaceholderRegExp.ReplaceAllLiteralString(tx.statements[0], literal), nil
+}
+
+// statementRecorder is a Transaction which records statements without executing them
+type statementRecorder struct {
+	statements []string
+}
+
+// Exec records a statement
+func (tx *statementRecorder) Exec(query string, args ...interface{}) (sql.Result, error) {
+	tx.statements = append(tx.statements, query)
+
+	return nil, nil
+}
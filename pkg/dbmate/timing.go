@@ -0,0 +1,185 @@
+package dbmate
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// TimingRecord describes when an applied migration was applied, and how long it took.
+// AppliedAt is zero for migrations applied before RecordTimings was enabled.
+type TimingRecord struct {
+	Version   string
+	AppliedAt time.Time
+	Duration  time.Duration
+}
+
+// timingColumns are the header of the report written by ExportTimings
+var timingColumns = []string{"version", "filename", "duration_ms", "applied_at"}
+
+// timingStore returns the driver as a TimingStore, or an error if it does
+// not support recording migration timings
+func (db *DB) timingStore(drv Driver) (TimingStore, error) {
+	store, ok := drv.(TimingStore)
+	if !ok {
+		return nil, fmt.Errorf("migration timings are not supported by driver: %s",
+			db.DatabaseURL.Scheme)
+	}
+
+	return store, nil
+}
+
+// ExportTimings writes a CSV report of the applied migrations to w, with the columns
+// version, filename, duration_ms and applied_at (in RFC 3339 format, UTC), ordered by
+// version. The timings are recorded by Migrate when RecordTimings is enabled, and are
+// empty for migrations applied before it was. The filename is empty for migrations
+// which no longer have a migration file. The database is not modified.
+func (db *DB) ExportTimings(w io.Writer) error {
+	return db.ExportTimingsContext(context.Background(), w)
+}
+
+// ExportTimingsContext is like ExportTimings, but gives up as soon as ctx is done
+func (db *DB) ExportTimingsContext(ctx context.Context, w io.Writer) error {
+	drv, err := db.GetDriver()
+	if err != nil {
+		return err
+	}
+
+	store, err := db.timingStore(drv)
+	if err != nil {
+		return err
+	}
+
+	files, err := db.findMigrations()
+	if err != nil {
+		return err
+	}
+
+	filenames := map[string]string{}
+	for _, filename := range files {
+		filenames[migrationVersion(filename)] = filename
+	}
+
+	_, sqlDB, err := db.connectDatabase(ctx)
+	if err != nil {
+		return err
+	}
+	defer mustClose(sqlDB)
+
+	records, err := store.SelectMigrationTimings(ctx, sqlDB)
+	if err != nil {
+		return fmt.Errorf("unable to read migration timings: %s", err)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Version < records[j].Version
+	})
+
+	out := csv.NewWriter(w)
+	if err := out.Write(timingColumns); err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		row := []string{record.Version, filenames[record.Version], "", ""}
+		if !record.AppliedAt.IsZero() {
+			row[2] = strconv.FormatInt(record.Duration.Milliseconds(), 10)
+			row[3] = record.AppliedAt.UTC().Format(time.RFC3339)
+		}
+
+		if err := out.Write(row); err != nil {
+			return err
+		}
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+// selectMigrationTimings returns the timings returned by a (version, applied_at,
+// duration_ms) query
+func selectMigrationTimings(ctx context.Context, db *sql.DB, query string) ([]TimingRecord, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer mustClose(rows)
+
+	records := []TimingRecord{}
+	for rows.Next() {
+		var version string
+		var appliedAt interface{}
+		var duration sql.NullInt64
+		if err := rows.Scan(&version, &appliedAt, &duration); err != nil {
+			return nil, err
+		}
+
+		record := TimingRecord{Version: version, Duration: time.Duration(duration.Int64) * time.Millisecond}
+		if record.AppliedAt, err = parseTimestamp(appliedAt); err != nil {
+			return nil, err
+		}
+
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+// timestampLayouts are the formats in which drivers return timestamps as text
+var timestampLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	time.RFC3339Nano,
+}
+
+// parseTimestamp converts a timestamp scanned from the database, which drivers return
+// either as a time.Time or as text, to a UTC time.Time. NULL is the zero time.
+func parseTimestamp(value interface{}) (time.Time, error) {
+	var text string
+	switch v := value.(type) {
+	case nil:
+		return time.Time{}, nil
+	case time.Time:
+		return v.UTC(), nil
+	case []byte:
+		text = string(v)
+	case string:
+		text = v
+	default:
+		return time.Time{}, fmt.Errorf("unexpected timestamp type %T", value)
+	}
+
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			return t.UTC(), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unable to parse timestamp `%s`", text)
+}
+
+// timeAgo describes how long before now t was, e.g. "3 days ago"
+func timeAgo(t, now time.Time) string {
+	d := now.Sub(t)
+	for _, unit := range []struct {
+		name string
+		size time.Duration
+	}{
+		{"day", 24 * time.Hour},
+		{"hour", time.Hour},
+		{"minute", time.Minute},
+	} {
+		if n := int64(d / unit.size); n == 1 {
+			return "1 " + unit.name + " ago"
+		} else if n > 1 {
+			return fmt.Sprintf("%d %ss ago", n, unit.name)
+		}
+	}
+
+	return "just now"
+}
@@ -0,0 +1,413 @@
+package dbmate
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// sensitiveSQLRegExp matches clauses which typically carry credentials,
+// e.g. `create user foo identified by secret` or `with password 'secret'`
+var sensitiveSQLRegExp = regexp.MustCompile(`(?i)\b(identified\s+by|password)(\s*=\s*|\s+)('[^']*'|"[^"]*"|[^\s;,)]+)`)
+
+// dataSourceNamer is implemented by drivers which open their database with sql.Open,
+// so that DB can open it through a tracing connector when TraceSQL is enabled
+type dataSourceNamer interface {
+	// dataSourceName returns the database/sql driver name and data source name
+	dataSourceName(*url.URL) (string, string)
+}
+
+// openDatabase opens the database at DatabaseURL. When TraceSQL is enabled, every
+// statement sent through the connection is logged, including migration bookkeeping.
+func (db *DB) openDatabase(drv Driver) (*sql.DB, error) {
+	u, err := db.connectionURL()
+	if err != nil {
+		return nil, err
+	}
+
+	namer, ok := drv.(dataSourceNamer)
+	if !db.TraceSQL || !ok {
+		return drv.Open(u)
+	}
+
+	name, dsn := namer.dataSourceName(u)
+
+	// look up the database/sql driver registered under name
+	sqlDB, err := sql.Open(name, dsn)
+	if err != nil {
+		return nil, err
+	}
+	sqlDriver := sqlDB.Driver()
+	mustClose(sqlDB)
+
+	return sql.OpenDB(tracingConnector{driver: sqlDriver, dsn: dsn, db: db}), nil
+}
+
+// traceTransaction wraps tx so that statements are logged when TraceSQL is enabled,
+// for drivers whose connections are not already traced by openDatabase
+func (db *DB) traceTransaction(drv Driver, tx Transaction) Transaction {
+	if _, ok := drv.(dataSourceNamer); !db.TraceSQL || ok {
+		return tx
+	}
+
+	return tracedTransaction{Transaction: tx, db: db}
+}
+
+// traceSQL logs a statement, along with its parameters and duration
+func (db *DB) traceSQL(query string, args []interface{}, start time.Time, err error) {
+	status := "ok"
+	if err != nil {
+		status = fmt.Sprintf("error: %s", err)
+	}
+
+	db.debugf("SQL: %s %s (%s, %s)\n", redactSQL(query), redactArgs(query, args),
+		time.Since(start), status)
+}
+
+// tracedTransaction wraps a Transaction and logs every statement executed
+// through it, along with its parameters and duration. ExecContext, QueryContext and
+// QueryRowContext require the underlying Transaction to implement GoMigrationTx,
+// which transactions opened by dbmate do.
+type tracedTransaction struct {
+	Transaction
+	db *DB
+}
+
+// Exec executes a statement on the underlying Transaction and logs it
+func (t tracedTransaction) Exec(query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	result, err := t.Transaction.Exec(query, args...)
+	t.db.traceSQL(query, args, start, err)
+
+	return result, err
+}
+
+// ExecContext executes a statement on the underlying Transaction and logs it
+func (t tracedTransaction) ExecContext(ctx context.Context, query string,
+	args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	result, err := t.Transaction.(GoMigrationTx).ExecContext(ctx, query, args...)
+	t.db.traceSQL(query, args, start, err)
+
+	return result, err
+}
+
+// QueryContext executes a query on the underlying Transaction and logs it
+func (t tracedTransaction) QueryContext(ctx context.Context, query string,
+	args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := t.Transaction.(GoMigrationTx).QueryContext(ctx, query, args...)
+	t.db.traceSQL(query, args, start, err)
+
+	return rows, err
+}
+
+// QueryRowContext executes a query returning at most one row on the underlying
+// Transaction and logs it
+func (t tracedTransaction) QueryRowContext(ctx context.Context, query string,
+	args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := t.Transaction.(GoMigrationTx).QueryRowContext(ctx, query, args...)
+	t.db.traceSQL(query, args, start, row.Err())
+
+	return row
+}
+
+// context returns the context of the underlying Transaction, if any
+func (t tracedTransaction) txContext() context.Context {
+	if switcher, ok := t.Transaction.(contextSwitcher); ok {
+		return switcher.txContext()
+	}
+
+	return context.Background()
+}
+
+// withContext returns a copy of the transaction executing statements with ctx, if the
+// underlying Transaction supports it
+func (t tracedTransaction) withContext(ctx context.Context) Transaction {
+	if switcher, ok := t.Transaction.(contextSwitcher); ok {
+		return tracedTransaction{Transaction: switcher.withContext(ctx), db: t.db}
+	}
+
+	return t
+}
+
+// tracingConnector opens connections with a database/sql driver, and wraps them
+// so that every statement is logged
+type tracingConnector struct {
+	driver driver.Driver
+	dsn    string
+	db     *DB
+}
+
+// Connect opens a traced connection
+func (c tracingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	var conn driver.Conn
+	var err error
+	if dc, ok := c.driver.(driver.DriverContext); ok {
+		var connector driver.Connector
+		if connector, err = dc.OpenConnector(c.dsn); err == nil {
+			conn, err = connector.Connect(ctx)
+		}
+	} else {
+		conn, err = c.driver.Open(c.dsn)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return tracingConn{Conn: conn, db: c.db}, nil
+}
+
+// Driver returns the underlying database/sql driver
+func (c tracingConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// tracingConn logs the statements executed on a driver connection. Optional
+// interfaces of the underlying connection are forwarded.
+type tracingConn struct {
+	driver.Conn
+	db *DB
+}
+
+// ExecContext executes a statement without preparing it, if the underlying
+// connection supports it
+func (c tracingConn) ExecContext(ctx context.Context, query string,
+	args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	start := time.Now()
+	result, err := execer.ExecContext(ctx, query, args)
+	if err != driver.ErrSkip {
+		c.db.traceSQL(query, namedValues(args), start, err)
+	}
+
+	return result, err
+}
+
+// QueryContext runs a query without preparing it, if the underlying
+// connection supports it
+func (c tracingConn) QueryContext(ctx context.Context, query string,
+	args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, query, args)
+	if err != driver.ErrSkip {
+		c.db.traceSQL(query, namedValues(args), start, err)
+	}
+
+	return rows, err
+}
+
+// Prepare prepares a statement whose executions are logged
+func (c tracingConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext prepares a statement whose executions are logged
+func (c tracingConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return tracingStmt{Stmt: stmt, query: query, db: c.db}, nil
+}
+
+// BeginTx starts a transaction, honoring opts if the underlying connection supports it
+func (c tracingConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+
+	if opts.Isolation != 0 || opts.ReadOnly {
+		return nil, fmt.Errorf("transaction options are not supported by the driver")
+	}
+
+	return c.Conn.Begin() // nolint:staticcheck
+}
+
+// Ping verifies the connection, if the underlying connection supports it
+func (c tracingConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+
+	return nil
+}
+
+// CheckNamedValue converts arguments like the underlying connection would
+func (c tracingConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+
+	return driver.ErrSkip
+}
+
+// ResetSession resets the underlying connection before it is reused
+func (c tracingConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+
+	return nil
+}
+
+// tracingStmt logs the executions of a prepared statement
+type tracingStmt struct {
+	driver.Stmt
+	query string
+	db    *DB
+}
+
+// Exec executes the statement and logs it
+func (s tracingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	start := time.Now()
+	result, err := s.Stmt.Exec(args) // nolint:staticcheck
+	s.db.traceSQL(s.query, values(args), start, err)
+
+	return result, err
+}
+
+// Query runs the statement and logs it
+func (s tracingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	start := time.Now()
+	rows, err := s.Stmt.Query(args) // nolint:staticcheck
+	s.db.traceSQL(s.query, values(args), start, err)
+
+	return rows, err
+}
+
+// ExecContext executes the statement and logs it
+func (s tracingStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := s.Stmt.(driver.StmtExecContext)
+	if !ok {
+		dargs, err := namedValuesToValues(args)
+		if err != nil {
+			return nil, err
+		}
+		return s.Exec(dargs)
+	}
+
+	start := time.Now()
+	result, err := execer.ExecContext(ctx, args)
+	s.db.traceSQL(s.query, namedValues(args), start, err)
+
+	return result, err
+}
+
+// QueryContext runs the statement and logs it
+func (s tracingStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := s.Stmt.(driver.StmtQueryContext)
+	if !ok {
+		dargs, err := namedValuesToValues(args)
+		if err != nil {
+			return nil, err
+		}
+		return s.Query(dargs)
+	}
+
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, args)
+	s.db.traceSQL(s.query, namedValues(args), start, err)
+
+	return rows, err
+}
+
+// CheckNamedValue converts arguments like the underlying statement would
+func (s tracingStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+
+	return driver.ErrSkip
+}
+
+// ColumnConverter returns the converter of the underlying statement, if any
+func (s tracingStmt) ColumnConverter(idx int) driver.ValueConverter {
+	if converter, ok := s.Stmt.(driver.ColumnConverter); ok { // nolint:staticcheck
+		return converter.ColumnConverter(idx)
+	}
+
+	return driver.DefaultParameterConverter
+}
+
+// namedValues returns the values of driver arguments, for logging
+func namedValues(args []driver.NamedValue) []interface{} {
+	result := make([]interface{}, len(args))
+	for i, arg := range args {
+		result[i] = arg.Value
+	}
+
+	return result
+}
+
+// values returns driver arguments as a slice of interface{}, for logging
+func values(args []driver.Value) []interface{} {
+	result := make([]interface{}, len(args))
+	for i, arg := range args {
+		result[i] = arg
+	}
+
+	return result
+}
+
+// namedValuesToValues converts named arguments for statements which only
+// support positional arguments
+func namedValuesToValues(args []driver.NamedValue) ([]driver.Value, error) {
+	result := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, fmt.Errorf("named arguments are not supported by the driver")
+		}
+		result[i] = arg.Value
+	}
+
+	return result, nil
+}
+
+// redactSQL masks credentials in a statement and collapses whitespace
+// so that it can be logged on a single line
+func redactSQL(query string) string {
+	query = sensitiveSQLRegExp.ReplaceAllString(query, "$1$2[REDACTED]")
+
+	return strings.Join(strings.Fields(query), " ")
+}
+
+// redactArgs formats the parameters of a statement for logging. Every parameter is
+// masked if the statement sets credentials (e.g. `alter user foo password = $1`),
+// and credentials within parameter values are masked like in statements.
+func redactArgs(query string, args []interface{}) string {
+	sensitive := sensitiveSQLRegExp.MatchString(query)
+
+	formatted := make([]string, len(args))
+	for i, arg := range args {
+		if sensitive {
+			formatted[i] = "[REDACTED]"
+		} else {
+			formatted[i] = redactSQL(fmt.Sprintf("%v", arg))
+		}
+	}
+
+	return "[" + strings.Join(formatted, " ") + "]"
+}
@@ -0,0 +1,27 @@
+package dbmate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactSQL(t *testing.T) {
+	require.Equal(t, "create table users ( id integer )",
+		redactSQL("create table users (\n  id integer\n)"))
+	require.Equal(t, "create user foo identified by [REDACTED]",
+		redactSQL("create user foo identified by secret"))
+	require.Equal(t, "alter role foo with password [REDACTED];",
+		redactSQL("alter role foo with password 'secret';"))
+	require.Equal(t, "set password = [REDACTED]",
+		redactSQL("set password = \"secret\""))
+}
+
+func TestRedactArgs(t *testing.T) {
+	require.Equal(t, "[1 foo]", redactArgs("insert into t values (?, ?)", []interface{}{1, "foo"}))
+	require.Equal(t, "[[REDACTED] [REDACTED]]",
+		redactArgs("update users set password = $1 where id = $2", []interface{}{"secret", 1}))
+	require.Equal(t, "[user=foo password=[REDACTED]]",
+		redactArgs("select $1", []interface{}{"user=foo password=secret"}))
+	require.Equal(t, "[]", redactArgs("select 1", nil))
+}
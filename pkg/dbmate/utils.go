@@ -3,13 +3,16 @@ package dbmate
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"unicode"
 )
@@ -32,14 +35,39 @@ func mustClose(c io.Closer) {
 }
 
 // ensureDir creates a directory if it does not already exist
-func ensureDir(dir string) error {
-	if err := os.MkdirAll(dir, 0755); err != nil {
+func ensureDir(fsys FileSystem, dir string, perm os.FileMode) error {
+	if err := fsys.MkdirAll(dir, perm); err != nil {
 		return fmt.Errorf("unable to create directory `%s`", dir)
 	}
 
 	return nil
 }
 
+// writeFileAtomic writes data to a temporary file and renames it to path, so that
+// readers never observe a partially written file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
 // runCommand runs a command and returns the stdout if successful
 func runCommand(name string, args ...string) ([]byte, error) {
 	var stdout, stderr bytes.Buffer
@@ -104,8 +132,13 @@ func trimLeadingSQLComments(data []byte) ([]byte, error) {
 // queryColumn runs a SQL statement and returns a slice of strings
 // it is assumed that the statement returns only one column
 // e.g. schema_migrations table
-func queryColumn(db *sql.DB, query string) ([]string, error) {
-	rows, err := db.Query(query)
+func queryColumn(db *sql.DB, query string, args ...interface{}) ([]string, error) {
+	return queryColumnContext(context.Background(), db, query, args...)
+}
+
+// queryColumnContext is like queryColumn, but gives up as soon as ctx is done
+func queryColumnContext(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
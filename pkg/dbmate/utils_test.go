@@ -1,7 +1,10 @@
 package dbmate
 
 import (
+	"io/ioutil"
 	"net/url"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -23,6 +26,34 @@ func TestDatabaseName_Empty(t *testing.T) {
 	require.Equal(t, "", name)
 }
 
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbmate")
+	require.NoError(t, err)
+	defer func() {
+		err := os.RemoveAll(dir)
+		require.NoError(t, err)
+	}()
+
+	path := filepath.Join(dir, "VERSION")
+	err = writeFileAtomic(path, []byte("one\n"), 0600)
+	require.NoError(t, err)
+	err = writeFileAtomic(path, []byte("two\n"), 0600)
+	require.NoError(t, err)
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "two\n", string(data))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// no temporary files are left behind
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+}
+
 func TestTrimLeadingSQLComments(t *testing.T) {
 	in := "--\n" +
 		"-- foo\n\n" +
@@ -0,0 +1,236 @@
+package dbmate
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// migrationsTableName is the table used by drivers to record applied migrations,
+// unless DB.MigrationsTableName is set
+const migrationsTableName = "schema_migrations"
+
+// migrationsTableNameRegexp matches the names accepted for DB.MigrationsTableName: a
+// table name, optionally qualified with its schema
+var migrationsTableNameRegexp = regexp.MustCompile(`^([A-Za-z_]\w*\.)?[A-Za-z_]\w*$`)
+
+// migrationsTable returns the name of the table recording applied migrations
+func (db *DB) migrationsTable() string {
+	if db.MigrationsTableName == "" {
+		return migrationsTableName
+	}
+
+	return db.MigrationsTableName
+}
+
+// splitMigrationsTable splits the name of a migrations table into its schema, which
+// is empty unless the name is qualified (e.g. `app.schema_migrations`), and its table
+func splitMigrationsTable(name string) (string, string) {
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+
+	return "", name
+}
+
+// MigrationIssue describes a problem found in a migration file by static checks.
+// Line is zero for issues which concern the whole file.
+type MigrationIssue struct {
+	Filename string
+	Line     int
+	Message  string
+}
+
+func (w MigrationIssue) String() string {
+	if w.Line == 0 {
+		return fmt.Sprintf("%s: %s", w.Filename, w.Message)
+	}
+
+	return fmt.Sprintf("%s:%d: %s", w.Filename, w.Line, w.Message)
+}
+
+// CheckMigrations runs every static check on the migration files, and returns all
+// issues found rather than stopping at the first one, so that they can be fixed in
+// a single pass. The database is not accessed. The checks report:
+//
+// * migration files sharing the same version
+// * migration files which cannot be parsed (e.g. a missing up block or include)
+// * statements referencing the migrations table
+// * migration files which are not valid UTF-8, when Strict is enabled
+// * schema file versions which do not match the migration files (see CheckSchemaFile)
+func (db *DB) CheckMigrations() ([]MigrationIssue, error) {
+	files, err := db.migrationFiles(db.migrationFilePattern())
+	if err != nil {
+		return nil, err
+	}
+
+	issues := checkDuplicateVersions(files)
+	for _, filename := range files {
+		fsys, dir, name := db.migrationPath(filename)
+		if _, _, err := parseMigration(fsys, dir, name); err != nil {
+			issues = append(issues, MigrationIssue{Filename: filename, Message: err.Error()})
+		}
+
+		if db.Strict {
+			issue, err := checkMigrationEncoding(fsys, name, filename)
+			if err != nil {
+				return nil, err
+			}
+			if issue != nil {
+				issues = append(issues, *issue)
+			}
+		}
+
+		fileIssues, err := db.checkMigrationFile(fsys, name, filename)
+		if err != nil {
+			return nil, err
+		}
+		issues = append(issues, fileIssues...)
+	}
+
+	schemaIssues, err := db.CheckSchemaFile()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(issues, schemaIssues...), nil
+}
+
+// CheckSchemaFile compares the migration versions recorded in the schema file with
+// the migration files. A version recorded without a migration file suggests that the
+// schema file was edited by hand (or dumped from another branch), while a migration
+// file which is not recorded means that the schema file was not regenerated after it
+// was added. The database is not accessed. Schema files which do not exist, use the
+// json format, or do not record any migrations are not checked.
+func (db *DB) CheckSchemaFile() ([]MigrationIssue, error) {
+	format, compressed := schemaFileFormat(db.SchemaFile)
+	if format != schemaFormatSQL {
+		return nil, nil
+	}
+
+	path := db.migrationsSchemaFile(compressed)
+	recorded, ok, err := readSchemaVersions(db.fs(), path, compressed)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	// duplicate versions are reported by CheckMigrations
+	files, err := db.migrationFiles(db.migrationFilePattern())
+	if err == nil {
+		files, err = db.mergeGoMigrations(files)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	issues := []MigrationIssue{}
+	versions := map[string]bool{}
+	for _, filename := range files {
+		ver := migrationVersion(filename)
+		versions[ver] = true
+		if !recorded[ver] {
+			issues = append(issues, MigrationIssue{
+				Filename: filename,
+				Message: fmt.Sprintf("migration is not recorded in the schema file `%s` "+
+					"(regenerate it with `dbmate dump`)", path),
+			})
+		}
+	}
+
+	missing := []string{}
+	for ver := range recorded {
+		if !versions[ver] {
+			missing = append(missing, ver)
+		}
+	}
+	sort.Strings(missing)
+	for _, ver := range missing {
+		issues = append(issues, MigrationIssue{
+			Filename: path,
+			Message: fmt.Sprintf("schema file records migration %s, which has no migration file "+
+				"(was the schema file edited by hand?)", ver),
+		})
+	}
+
+	return issues, nil
+}
+
+// checkDuplicateVersions reports migration files sharing a version with an earlier file
+func checkDuplicateVersions(files []string) []MigrationIssue {
+	issues := []MigrationIssue{}
+	seen := map[string]string{}
+	for _, filename := range files {
+		ver := migrationVersion(filename)
+		if first, ok := seen[ver]; ok {
+			issues = append(issues, MigrationIssue{
+				Filename: filename,
+				Message:  fmt.Sprintf("duplicate migration version %s, also used by %s", ver, first),
+			})
+			continue
+		}
+
+		seen[ver] = filename
+	}
+
+	return issues
+}
+
+// checkMigrationsTableReferences scans the lines of a migration for references to
+// the migrations table. Statements touching this table (usually a copy-paste mistake)
+// can corrupt the record of applied migrations. This is a heuristic string match,
+// comment lines are ignored.
+func checkMigrationsTableReferences(filename, contents, table string) []MigrationIssue {
+	re := regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(table) + `\b`)
+
+	var warnings []MigrationIssue
+	for i, line := range strings.Split(contents, "\n") {
+		if isCommentLine(line) || !re.MatchString(line) {
+			continue
+		}
+
+		warnings = append(warnings, MigrationIssue{
+			Filename: filename,
+			Line:     i + 1,
+			Message:  fmt.Sprintf("migration references the migrations table `%s`", table),
+		})
+	}
+
+	return warnings
+}
+
+// checkMigrationEncoding reports a migration file which is not valid UTF-8 (e.g. saved
+// as Latin-1), whose strings would be garbled when executed, at the line of the first
+// invalid byte. It returns nil if the file is valid.
+func checkMigrationEncoding(fsys fs.FS, name, filename string) (*MigrationIssue, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		if !utf8.Valid(line) {
+			return &MigrationIssue{
+				Filename: filename,
+				Line:     i + 1,
+				Message:  "migration file is not valid UTF-8",
+			}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// checkMigrationFile runs static checks on a migration file
+func (db *DB) checkMigrationFile(fsys fs.FS, name, filename string) ([]MigrationIssue, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	_, table := splitMigrationsTable(db.migrationsTable())
+	return checkMigrationsTableReferences(filename, string(data), table), nil
+}
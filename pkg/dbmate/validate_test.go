@@ -0,0 +1,159 @@
+package dbmate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckMigrationsTableReferences(t *testing.T) {
+	migration := `-- migrate:up
+-- never touch schema_migrations here
+create table users (id integer);
+delete from SCHEMA_MIGRATIONS where version = '1';
+
+-- migrate:down
+drop table users;
+insert into public.schema_migrations (version) values ('1');
+create table schema_migrations_archive (id integer);
+`
+
+	warnings := checkMigrationsTableReferences("20200101000000_users.sql", migration, migrationsTableName)
+	require.Len(t, warnings, 2)
+	require.Equal(t, "20200101000000_users.sql:4: migration references the migrations table "+
+		"`schema_migrations`", warnings[0].String())
+	require.Equal(t, 8, warnings[1].Line)
+
+	require.Empty(t, checkMigrationsTableReferences("x.sql",
+		"-- migrate:up\ncreate table users (id integer);\n", migrationsTableName))
+}
+
+func TestCheckMigrations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbmate")
+	require.NoError(t, err)
+	defer func() {
+		err := os.RemoveAll(dir)
+		require.NoError(t, err)
+	}()
+
+	files := map[string]string{
+		"001_users.sql":    "-- migrate:up\ncreate table users (id integer);\n",
+		"001_accounts.sql": "-- migrate:up\ncreate table accounts (id integer);\n",
+		"002_posts.sql":    "create table posts (id integer);\n",
+		"003_cleanup.sql":  "-- migrate:up\ndelete from schema_migrations;\n-- include: missing.sql\n",
+	}
+	for name, contents := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644)
+		require.NoError(t, err)
+	}
+
+	db := New(nil)
+	db.MigrationsDir = dir
+	issues, err := db.CheckMigrations()
+	require.NoError(t, err)
+
+	messages := []string{}
+	for _, issue := range issues {
+		messages = append(messages, issue.String())
+	}
+	require.Equal(t, []string{
+		"001_users.sql: duplicate migration version 001, also used by 001_accounts.sql",
+		"002_posts.sql: dbmate requires each migration to define an up bock with '-- migrate:up'",
+		"003_cleanup.sql: unable to include `" + filepath.Join(dir, "missing.sql") +
+			"`: open " + filepath.Join(dir, "missing.sql") + ": no such file or directory",
+		"003_cleanup.sql:2: migration references the migrations table `schema_migrations`",
+	}, messages)
+}
+
+func TestCheckMigrationEncoding(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbmate")
+	require.NoError(t, err)
+	defer func() {
+		err := os.RemoveAll(dir)
+		require.NoError(t, err)
+	}()
+
+	// "café" saved as Latin-1
+	err = ioutil.WriteFile(filepath.Join(dir, "001_latin1.sql"),
+		[]byte("-- migrate:up\ninsert into drinks (name) values ('caf\xe9');\n"), 0644)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(dir, "002_utf8.sql"),
+		[]byte("-- migrate:up\ninsert into drinks (name) values ('café');\n"), 0644)
+	require.NoError(t, err)
+
+	// only checked in strict mode
+	db := New(nil)
+	db.MigrationsDir = dir
+	issues, err := db.CheckMigrations()
+	require.NoError(t, err)
+	require.Empty(t, issues)
+
+	db.Strict = true
+	issues, err = db.CheckMigrations()
+	require.NoError(t, err)
+	require.Equal(t, []MigrationIssue{
+		{Filename: "001_latin1.sql", Line: 2, Message: "migration file is not valid UTF-8"},
+	}, issues)
+
+	// migrating fails before applying the file
+	u := sqliteTestURL(t)
+	db = newTestDB(t, u)
+	db.MigrationsDir = dir
+	db.Strict = true
+	err = db.Drop()
+	require.NoError(t, err)
+	err = db.Migrate()
+	require.EqualError(t, err, "001_latin1.sql:2: migration file is not valid UTF-8")
+}
+
+func TestCheckSchemaFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbmate")
+	require.NoError(t, err)
+	defer func() {
+		err := os.RemoveAll(dir)
+		require.NoError(t, err)
+	}()
+
+	for _, name := range []string{"001_users.sql", "002_posts.sql"} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte("-- migrate:up\n"), 0644)
+		require.NoError(t, err)
+	}
+
+	db := New(nil)
+	db.MigrationsDir = dir
+	db.SchemaFile = filepath.Join(dir, "schema.sql")
+
+	// missing schema files are not checked
+	issues, err := db.CheckSchemaFile()
+	require.NoError(t, err)
+	require.Empty(t, issues)
+
+	schema := "CREATE TABLE users (id integer, name varchar(255) default 'x');\n" +
+		"-- Dbmate schema migrations\n" +
+		"INSERT INTO schema_migrations (version) VALUES\n  ('001'),\n  ('003');\n"
+	err = ioutil.WriteFile(db.SchemaFile, []byte(schema), 0644)
+	require.NoError(t, err)
+
+	issues, err = db.CheckSchemaFile()
+	require.NoError(t, err)
+	require.Len(t, issues, 2)
+	require.Equal(t, "002_posts.sql: migration is not recorded in the schema file `"+db.SchemaFile+
+		"` (regenerate it with `dbmate dump`)", issues[0].String())
+	require.Equal(t, db.SchemaFile+": schema file records migration 003, which has no migration file "+
+		"(was the schema file edited by hand?)", issues[1].String())
+
+	// schema files are checked along with migration files
+	issues, err = db.CheckMigrations()
+	require.NoError(t, err)
+	require.Len(t, issues, 2)
+
+	// schema files without recorded migrations are not checked
+	err = ioutil.WriteFile(db.SchemaFile, []byte("CREATE TABLE users (id integer);\n"), 0644)
+	require.NoError(t, err)
+	issues, err = db.CheckSchemaFile()
+	require.NoError(t, err)
+	require.Empty(t, issues)
+}